@@ -0,0 +1,212 @@
+package kognit
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// AppendToTar adds files, and the contents of any directories among them,
+// to the tar archive at archivePath under their given paths.
+//
+// Uncompressed tar archives are extended in place by writing over their
+// end-of-archive trailer. A compressed stream cannot be appended to, so
+// .tar.gz and .tar.zst archives are rewritten: the existing entries are
+// copied into a new archive followed by the new ones, which then replaces
+// the original. bzip2 archives cannot be rewritten and return ErrBZIP2Encode.
+// Archives split into volumes are always rewritten, into volumes the size
+// of their first one.
+func AppendToTar(archivePath string, files []string) error {
+	entries := []archiveFile{}
+	for _, file := range files {
+		found, err := dirArchiveFiles(file, ArchiveOptions{})
+		if err != nil {
+			return err
+		}
+		entries = append(entries, found...)
+	}
+
+	base, volumeSize, err := splitArchive(archivePath)
+	if err != nil {
+		return err
+	}
+
+	plain, err := isPlainTar(archivePath)
+	if err != nil {
+		return err
+	}
+	if plain {
+		if volumeSize == 0 {
+			return appendToPlainTar(archivePath, entries)
+		}
+		return TAR.rewriteTar(base, volumeSize, true, entries)
+	}
+
+	a, err := detectArchiveFormat(archivePath)
+	if err != nil {
+		return err
+	}
+	switch a {
+	case TAR, ZSTD:
+		return a.rewriteTar(base, volumeSize, false, entries)
+	case BZIP2:
+		return ErrBZIP2Encode
+	}
+	return fmt.Errorf("%s: not a tar archive: %w", archivePath, ErrUnknownArchiveFormat)
+}
+
+// isPlainTar looks for the ustar magic in the first header block.
+func isPlainTar(path string) (bool, error) {
+	f, _, err := openArchive(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	block := make([]byte, 512)
+	if _, err := io.ReadFull(f, block); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return false, nil
+		}
+		return false, err
+	}
+	return bytes.HasPrefix(block[257:], []byte("ustar")), nil
+}
+
+func appendToPlainTar(archivePath string, entries []archiveFile) error {
+	f, err := os.OpenFile(archivePath, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// The reader leaves the file positioned at the start of each entry's
+	// data, so the archive ends after the last entry's padded data.
+	end := int64(0)
+	r := tar.NewReader(f)
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		offset, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		end = offset + (header.Size+511)/512*512
+	}
+
+	if _, err := f.Seek(end, io.SeekStart); err != nil {
+		return err
+	}
+	if err := writeTarArchive(f, entries, ArchiveOptions{}); err != nil {
+		return err
+	}
+
+	// Drop whatever trailer padding the old archive had beyond the new one.
+	offset, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(offset); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// rewriteTar replaces the archive at archivePath, or the volumes numbered
+// from it when volumeSize is set, with a copy followed by entries. The copy
+// is recompressed with a unless plain is set.
+func (a DirectoryCompressionAlgorithm) rewriteTar(archivePath string, volumeSize int64, plain bool, entries []archiveFile) error {
+	src, _, err := openArchive(archivePath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	first := archivePath
+	if volumeSize > 0 {
+		first = volumeName(archivePath, 0)
+	}
+	info, err := os.Stat(first)
+	if err != nil {
+		return err
+	}
+
+	var dr io.ReadCloser = src
+	if !plain {
+		if dr, err = a.decompressor(src); err != nil {
+			return err
+		}
+		defer dr.Close()
+	}
+
+	// The rewritten archive replaces the original, so it keeps its mode.
+	var tmp archiveOutput
+	if volumeSize > 0 {
+		tmp = &volumeWriter{dest: archivePath, size: volumeSize, perm: info.Mode().Perm()}
+	} else {
+		f, err := createPending(archivePath)
+		if err != nil {
+			return err
+		}
+		if err := f.Chmod(info.Mode().Perm()); err != nil {
+			f.discard()
+			return err
+		}
+		tmp = f
+	}
+	defer tmp.discard()
+
+	var cw io.WriteCloser = nopWriteCloser{tmp}
+	if !plain {
+		if cw, err = a.compressor(tmp, filepath.Base(archivePath), ArchiveOptions{}); err != nil {
+			return err
+		}
+	}
+
+	r := tar.NewReader(dr)
+	w := tar.NewWriter(cw)
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := w.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, r); err != nil {
+			return err
+		}
+	}
+
+	for _, entry := range entries {
+		if _, err := addFileToTar(w, entry, ArchiveOptions{}); err != nil {
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := cw.Close(); err != nil {
+		return err
+	}
+	return tmp.commit()
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
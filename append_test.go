@@ -0,0 +1,49 @@
+package kognit
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestAppendToCompressedTar(t *testing.T) {
+	for _, algo := range []DirectoryCompressionAlgorithm{TAR, ZSTD} {
+		t.Run(algo.extension(), func(t *testing.T) {
+			dir := t.TempDir()
+			archive := writeArchive(t, dir, algo, map[string][]byte{"old.txt": []byte("old")})
+			if err := os.Chmod(archive, 0640); err != nil {
+				t.Fatal(err)
+			}
+			added := filepath.Join(dir, "new.txt")
+			writeTree(t, dir, map[string]string{"new.txt": "new"})
+
+			if err := AppendToTar(archive, []string{added}); err != nil {
+				t.Fatal(err)
+			}
+
+			entries, err := ListArchive(archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			names := []string{}
+			for _, e := range entries {
+				names = append(names, e.Name)
+			}
+			sort.Strings(names)
+			want := []string{filepath.ToSlash(added), "old.txt"}
+			sort.Strings(want)
+			if len(names) != 2 || names[0] != want[0] || names[1] != want[1] {
+				t.Errorf("entries after append = %v, want %v", names, want)
+			}
+
+			info, err := os.Stat(archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0640 {
+				t.Errorf("archive mode after append = %v, want 0640", info.Mode().Perm())
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package kognit
+
+import (
+	"archive/tar"
+	"errors"
+)
+
+// ArchiveOptions configures the directory encoders and extractors. The zero
+// value matches Encode and Decode.
+type ArchiveOptions struct {
+	// Passphrase, when set, encrypts the archive with AES-GCM using a key
+	// derived with scrypt. The same passphrase is needed to decode it.
+	Passphrase string
+	// Dedup stores files whose content was already archived as tar hard
+	// links to the first copy. Zip has no link entries and ignores it.
+	Dedup bool
+	// Level is the compression level: 1-9 for ZIP and TAR, 1-22 for ZSTD.
+	// Zero selects each encoder's default.
+	Level int
+	// OnConflict decides what the extractors do when a file they are about
+	// to write already exists.
+	OnConflict ConflictPolicy
+	// MaxFileBytes and MaxTotalBytes cap the size of any single extracted
+	// file and of all of them together. Zero means no limit.
+	MaxFileBytes  int64
+	MaxTotalBytes int64
+	// RejectSpecialFiles, when set, makes tar extraction fail on device and FIFO
+	// entries instead of skipping them.
+	RejectSpecialFiles bool
+	// Concurrency is the number of files read, and for zip compressed,
+	// in parallel while encoding. The output does not depend on it.
+	Concurrency int
+	// StripComponents drops that many leading path segments from every
+	// entry name on extraction, like tar's --strip-components. Entries
+	// with no segments left are skipped.
+	StripComponents int
+	// Prefix nests every extracted entry under this directory of dest.
+	Prefix string
+	// MaxDepth stops the encoder from descending more than this many
+	// levels below the source directory. Zero means no limit.
+	MaxDepth int
+	// Durable syncs the archive to disk before the encoder returns, so a
+	// crash cannot leave a truncated file behind.
+	Durable bool
+	// ZipPassword decrypts zip entries encrypted with ZipCrypto or WinZip
+	// AES by other tools. It is unrelated to Passphrase.
+	ZipPassword string
+	// MaxRatio aborts extraction once an entry expands to more than this
+	// many times its compressed size, guarding against zip bombs. Tar
+	// entries have no compressed size of their own, so for them the
+	// ratio of the whole stream so far is checked. Zero means no limit.
+	MaxRatio int
+	// Xattrs archives extended attributes in tar PAX records and restores
+	// them on extraction. It is only available on Linux and macOS, and zip
+	// ignores it.
+	Xattrs bool
+	// Resume skips files that an interrupted extraction already finished,
+	// recognised by a matching size and modification time.
+	Resume bool
+	// SkipVanished leaves out files deleted between listing the source and
+	// archiving them, noting each through SetLogger's logger, instead of
+	// failing. Tar entries of files that change size meanwhile keep the
+	// size first seen.
+	SkipVanished bool
+	// ExcludeLargerThan leaves files bigger than this many bytes out of the
+	// archive. ParseSize reads it from strings like "50MB". Zero means no
+	// limit.
+	ExcludeLargerThan int64
+	// TarFormat forces tar entries into tar.FormatUSTAR, tar.FormatPAX or
+	// tar.FormatGNU. An entry the format cannot represent, such as a path
+	// too long for USTAR or extended attributes under GNU, fails the
+	// encode. The default picks the simplest format each entry fits.
+	TarFormat tar.Format
+	// Checksums stores each file's SHA-256 in the archive: in a PAX record
+	// of its tar entry, or in a list at the end of a zip. Decoding checks
+	// any checksums an archive carries and fails with ErrHashMismatch when
+	// an extracted file differs.
+	Checksums bool
+	// VolumeSize splits the encoded archive into volumes of at most this
+	// many bytes, named after the destination with .001, .002 and so on
+	// appended. Decoding reads the volumes in order when given the
+	// destination name or the first volume.
+	VolumeSize int64
+	// FollowSymlinks archives what symlinks in the source point to, under
+	// the link's name, instead of the links themselves. Dangling links and
+	// links back into a directory being walked are skipped and logged.
+	FollowSymlinks bool
+	// MaxEntries caps how many entries an extraction writes, directories
+	// included; the entry past the limit fails with ErrTooManyEntries.
+	// Zero means no limit.
+	MaxEntries int
+}
+
+type ConflictPolicy int
+
+const (
+	Overwrite ConflictPolicy = iota
+	Skip
+	Error
+)
+
+var ErrDestinationExists = errors.New("destination file already exists")
+
+// Archiver encodes and decodes directories with one algorithm and a fixed
+// set of options. The options are copied when the Archiver is built and
+// never changed afterwards, and every call keeps its state to itself, so a
+// single Archiver can serve concurrent Encode and Decode calls.
+type Archiver struct {
+	algo DirectoryCompressionAlgorithm
+	opts ArchiveOptions
+}
+
+type Option func(*ArchiveOptions)
+
+func NewArchiver(algo DirectoryCompressionAlgorithm, opts ...Option) *Archiver {
+	a := &Archiver{algo: algo}
+	for _, opt := range opts {
+		opt(&a.opts)
+	}
+	return a
+}
+
+// Encode archives the files under src into dest.
+func (a *Archiver) Encode(src, dest string) error {
+	entries, err := dirArchiveFiles(src, a.opts)
+	if err != nil {
+		return err
+	}
+	return a.algo.encode(entries, dest, a.opts)
+}
+
+// Decode extracts the archive at src into dest.
+func (a *Archiver) Decode(src, dest string) error {
+	return a.algo.decode(src, dest, a.opts)
+}
+
+// DecodeWithResult is Decode that also reports the files and directories
+// it created.
+func (a *Archiver) DecodeWithResult(src, dest string) (DecodeResult, error) {
+	x := &extraction{opts: a.opts}
+	err := a.algo.extract(src, dest, x)
+	return x.result, err
+}
+
+// WithOptions replaces every option at once.
+func WithOptions(opts ArchiveOptions) Option {
+	return func(o *ArchiveOptions) { *o = opts }
+}
+
+func WithPassphrase(passphrase string) Option {
+	return func(o *ArchiveOptions) { o.Passphrase = passphrase }
+}
+
+func WithDedup() Option {
+	return func(o *ArchiveOptions) { o.Dedup = true }
+}
+
+func WithLevel(level int) Option {
+	return func(o *ArchiveOptions) { o.Level = level }
+}
+
+func WithConflictPolicy(policy ConflictPolicy) Option {
+	return func(o *ArchiveOptions) { o.OnConflict = policy }
+}
+
+func WithMaxFileBytes(n int64) Option {
+	return func(o *ArchiveOptions) { o.MaxFileBytes = n }
+}
+
+func WithMaxTotalBytes(n int64) Option {
+	return func(o *ArchiveOptions) { o.MaxTotalBytes = n }
+}
+
+func WithRejectSpecialFiles() Option {
+	return func(o *ArchiveOptions) { o.RejectSpecialFiles = true }
+}
+
+func WithConcurrency(n int) Option {
+	return func(o *ArchiveOptions) { o.Concurrency = n }
+}
+
+func WithStripComponents(n int) Option {
+	return func(o *ArchiveOptions) { o.StripComponents = n }
+}
+
+func WithPrefix(prefix string) Option {
+	return func(o *ArchiveOptions) { o.Prefix = prefix }
+}
+
+func WithMaxDepth(n int) Option {
+	return func(o *ArchiveOptions) { o.MaxDepth = n }
+}
+
+func WithDurable() Option {
+	return func(o *ArchiveOptions) { o.Durable = true }
+}
+
+func WithZipPassword(password string) Option {
+	return func(o *ArchiveOptions) { o.ZipPassword = password }
+}
+
+func WithMaxRatio(ratio int) Option {
+	return func(o *ArchiveOptions) { o.MaxRatio = ratio }
+}
+
+func WithXattrs() Option {
+	return func(o *ArchiveOptions) { o.Xattrs = true }
+}
+
+func WithResume() Option {
+	return func(o *ArchiveOptions) { o.Resume = true }
+}
+
+func WithSkipVanished() Option {
+	return func(o *ArchiveOptions) { o.SkipVanished = true }
+}
+
+func WithExcludeLargerThan(n int64) Option {
+	return func(o *ArchiveOptions) { o.ExcludeLargerThan = n }
+}
+
+func WithTarFormat(format tar.Format) Option {
+	return func(o *ArchiveOptions) { o.TarFormat = format }
+}
+
+func WithChecksums() Option {
+	return func(o *ArchiveOptions) { o.Checksums = true }
+}
+
+func WithVolumeSize(size int64) Option {
+	return func(o *ArchiveOptions) { o.VolumeSize = size }
+}
+
+func WithFollowSymlinks() Option {
+	return func(o *ArchiveOptions) { o.FollowSymlinks = true }
+}
+
+func WithMaxEntries(n int) Option {
+	return func(o *ArchiveOptions) { o.MaxEntries = n }
+}
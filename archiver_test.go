@@ -0,0 +1,71 @@
+package kognit
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewArchiverAppliesOptions(t *testing.T) {
+	a := NewArchiver(TAR,
+		WithOptions(ArchiveOptions{Level: 3, Prefix: "base", MaxEntries: 5}),
+		WithLevel(7),
+		WithDedup(),
+		WithConflictPolicy(Skip),
+		WithMaxFileBytes(10),
+		WithTarFormat(tar.FormatPAX),
+	)
+	want := ArchiveOptions{
+		Level:        7, // the later option wins
+		Prefix:       "base",
+		MaxEntries:   5,
+		Dedup:        true,
+		OnConflict:   Skip,
+		MaxFileBytes: 10,
+		TarFormat:    tar.FormatPAX,
+	}
+	if a.algo != TAR || a.opts != want {
+		t.Errorf("NewArchiver built %v with %+v, want %+v", a.algo, a.opts, want)
+	}
+}
+
+func TestArchiverRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	files := map[string]string{"a.txt": "alpha", "sub/b.txt": "bravo", "sub/copy.txt": "bravo"}
+	writeTree(t, src, files)
+
+	for _, algo := range []DirectoryCompressionAlgorithm{ZIP, TAR, ZSTD} {
+		archive := filepath.Join(dir, "out"+algo.extension())
+		a := NewArchiver(algo, WithDedup(), WithLevel(1), WithChecksums())
+		if err := a.Encode(src, archive); err != nil {
+			t.Fatal(err)
+		}
+		out := filepath.Join(dir, "out-"+algo.extension())
+		result, err := a.DecodeWithResult(archive, out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertTree(t, filepath.Join(out, src), files)
+		if len(result.Files) != len(files) {
+			t.Errorf("%s: DecodeWithResult listed %d files, want %d", algo.extension(), len(result.Files), len(files))
+		}
+
+		// The enum methods are the default Archiver writing next to src. Only
+		// zip is compared byte for byte, as gzip headers record the time.
+		if err := algo.Encode(src); err != nil {
+			t.Fatal(err)
+		}
+		if algo == ZIP {
+			viaEnum, err := ioutil.ReadFile(src + algo.extension())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(viaEnum, archiveBytes(t, algo, src)) {
+				t.Errorf("%s: Encode differs from NewArchiver(%s).Encode", algo.extension(), algo.extension())
+			}
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package kognit
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"os"
+	"sort"
+	"time"
+)
+
+// BuildArchive encodes entries, keyed by the name each is stored under, into
+// an archive held in memory. Entries are written in name order and stored as
+// regular files with mode 0644 and the current time.
+func BuildArchive(entries map[string][]byte, algo DirectoryCompressionAlgorithm) ([]byte, error) {
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	now := time.Now()
+	buf := &bytes.Buffer{}
+
+	switch algo {
+	case ZIP:
+		w := zip.NewWriter(buf)
+		for _, name := range names {
+			info := memFileInfo{name: name, size: int64(len(entries[name])), modTime: now}
+			if err := addToZip(w, archiveFile{name: name}, info, bytes.NewReader(entries[name])); err != nil {
+				return nil, err
+			}
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	case TAR, ZSTD, BZIP2:
+		cw, err := algo.compressor(buf, "", ArchiveOptions{})
+		if err != nil {
+			return nil, err
+		}
+		w := tar.NewWriter(cw)
+		for _, name := range names {
+			info := memFileInfo{name: name, size: int64(len(entries[name])), modTime: now}
+			if err := addToTar(w, archiveFile{name: name}, info, bytes.NewReader(entries[name]), ArchiveOptions{}); err != nil {
+				return nil, err
+			}
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		if err := cw.Close(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, algo.unsupported()
+	}
+	return buf.Bytes(), nil
+}
+
+// memFileInfo describes an in-memory payload to the archive header helpers.
+type memFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (m memFileInfo) Name() string       { return m.name }
+func (m memFileInfo) Size() int64        { return m.size }
+func (m memFileInfo) Mode() os.FileMode  { return 0644 }
+func (m memFileInfo) ModTime() time.Time { return m.modTime }
+func (m memFileInfo) IsDir() bool        { return false }
+func (m memFileInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,81 @@
+package kognit
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+// readArchiveBytes decodes an archive held in memory back into a map of
+// its regular files.
+func readArchiveBytes(t *testing.T, data []byte, algo DirectoryCompressionAlgorithm) map[string][]byte {
+	t.Helper()
+	entries := map[string][]byte{}
+	if algo == ZIP {
+		r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range r.File {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, err := ioutil.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries[f.Name] = content
+		}
+		return entries
+	}
+
+	dr, err := algo.decompressor(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dr.Close()
+	r := tar.NewReader(dr)
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[header.Name] = content
+	}
+}
+
+func TestBuildArchiveRoundTrip(t *testing.T) {
+	entries := map[string][]byte{
+		"a.txt":          []byte("alpha"),
+		"sub/b.bin":      {0, 1, 2, 255},
+		"sub/deep/empty": {},
+		"large.txt":      bytes.Repeat([]byte("lima "), 10000),
+	}
+	for _, algo := range []DirectoryCompressionAlgorithm{ZIP, TAR, ZSTD} {
+		data, err := BuildArchive(entries, algo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := readArchiveBytes(t, data, algo); !reflect.DeepEqual(got, entries) {
+			t.Errorf("%s: built archive holds %q, want %q", algo.extension(), got, entries)
+		}
+	}
+
+	if _, err := BuildArchive(entries, BZIP2); !errors.Is(err, ErrBZIP2Encode) {
+		t.Errorf("BuildArchive for BZIP2 = %v, want ErrBZIP2Encode", err)
+	}
+}
@@ -0,0 +1,166 @@
+package kognit
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var ErrHashMismatch = errors.New("extracted file does not match its stored SHA-256")
+
+// Tar entries carry their checksum in a PAX record. Zip has no room for
+// one, so a zip archive ends with a sha256sum-style list of its entries
+// under zipChecksumsName instead.
+const (
+	paxSHA256        = "KOGNIT.sha256"
+	zipChecksumsName = ".kognit.sha256"
+)
+
+// zipChecksums hashes zip entries as they are written. A nil *zipChecksums
+// does nothing, so writers can use it unconditionally.
+type zipChecksums struct {
+	names  []string
+	hashes map[string]hash.Hash
+}
+
+func newZipChecksums(opts ArchiveOptions) *zipChecksums {
+	if !opts.Checksums {
+		return nil
+	}
+	return &zipChecksums{hashes: map[string]hash.Hash{}}
+}
+
+// tee returns r, hashing whatever is read from it on behalf of the entry
+// stored as name.
+func (c *zipChecksums) tee(name string, r io.Reader) io.Reader {
+	if c == nil {
+		return r
+	}
+	name = filepath.ToSlash(name)
+	h := sha256.New()
+	c.names = append(c.names, name)
+	c.hashes[name] = h
+	return io.TeeReader(r, h)
+}
+
+// write adds the checksum list as the archive's last entry. It is stored
+// uncompressed so that it never goes through a precompressed Deflate path.
+func (c *zipChecksums) write(w *zip.Writer) error {
+	if c == nil {
+		return nil
+	}
+	list := &bytes.Buffer{}
+	for _, name := range c.names {
+		fmt.Fprintf(list, "%s  %s\n", hex.EncodeToString(c.hashes[name].Sum(nil)), name)
+	}
+
+	header := &zip.FileHeader{Name: zipChecksumsName, Method: zip.Store, Modified: time.Now()}
+	header.SetMode(0644)
+	writer, err := w.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(list.Bytes())
+	return err
+}
+
+// readZipChecksums loads the checksum list from r, if it has one.
+func readZipChecksums(r *zip.Reader, archive io.ReaderAt, password string) (map[string]string, error) {
+	for _, f := range r.File {
+		if f.Name != zipChecksumsName {
+			continue
+		}
+		rc, err := openZipEntry(f, archive, password)
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return parseManifest(rc)
+	}
+	return nil, nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hashTarData reads the content about to be written for entry and sets its
+// checksum from those same bytes, so it matches the entry even when tarData
+// had to fit a changed file to its header. The bytes are then written from
+// the returned copy, which the caller closes. A file that grew reads one
+// byte too many, for the tar writer to reject as it would unbuffered.
+func hashTarData(entry *archiveFile, data io.Reader, info os.FileInfo) (io.ReadCloser, error) {
+	h := sha256.New()
+	data = io.TeeReader(io.LimitReader(data, info.Size()+1), h)
+
+	if info.Size() <= maxBufferedEntry {
+		buf, err := ioutil.ReadAll(data)
+		if err != nil {
+			return nil, err
+		}
+		entry.sha256 = hex.EncodeToString(h.Sum(nil))
+		return ioutil.NopCloser(bytes.NewReader(buf)), nil
+	}
+
+	tmp, err := ioutil.TempFile("", "kognit-*.spool")
+	if err != nil {
+		return nil, err
+	}
+	spool := spooledFile{tmp}
+	if _, err := io.Copy(tmp, data); err != nil {
+		spool.Close()
+		return nil, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		spool.Close()
+		return nil, err
+	}
+	entry.sha256 = hex.EncodeToString(h.Sum(nil))
+	return spool, nil
+}
+
+// spooledFile is a temporary file that is removed when closed.
+type spooledFile struct {
+	*os.File
+}
+
+func (s spooledFile) Close() error {
+	err := s.File.Close()
+	os.Remove(s.Name())
+	return err
+}
+
+// verifyHash returns r, failing its final read with ErrHashMismatch if the
+// content does not hash to want. An empty want disables the check.
+func verifyHash(r io.Reader, path, want string) io.Reader {
+	if want == "" {
+		return r
+	}
+	return &hashReader{r: r, hash: sha256.New(), want: want, path: path}
+}
+
+type hashReader struct {
+	r    io.Reader
+	hash hash.Hash
+	want string
+	path string
+}
+
+func (h *hashReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	h.hash.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(h.hash.Sum(nil)) != h.want {
+		return n, fmt.Errorf("%s: %w", h.path, ErrHashMismatch)
+	}
+	return n, err
+}
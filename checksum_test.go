@@ -0,0 +1,89 @@
+package kognit
+
+import (
+	"archive/tar"
+	"errors"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+func TestChecksumMismatchFailsDecode(t *testing.T) {
+	wrong := sha256Hex([]byte("something else"))
+
+	t.Run("tar", func(t *testing.T) {
+		dir := t.TempDir()
+		archive := filepath.Join(dir, "corrupt.tar.gz")
+		writeTarGz(t, archive, []tarEntry{{
+			header:  tar.Header{Name: "a.txt", Typeflag: tar.TypeReg, Mode: 0644, PAXRecords: map[string]string{paxSHA256: wrong}},
+			content: "tampered",
+		}})
+		if err := DecodeInto(archive, filepath.Join(dir, "out"), TAR); !errors.Is(err, ErrHashMismatch) {
+			t.Errorf("DecodeInto = %v, want ErrHashMismatch", err)
+		}
+	})
+
+	t.Run("zip", func(t *testing.T) {
+		dir := t.TempDir()
+		archive := filepath.Join(dir, "corrupt.zip")
+		writeZip(t, archive, []zipEntry{
+			{name: "a.txt", content: "tampered"},
+			{name: zipChecksumsName, content: wrong + "  a.txt\n"},
+		})
+		if err := DecodeInto(archive, filepath.Join(dir, "out"), ZIP); !errors.Is(err, ErrHashMismatch) {
+			t.Errorf("DecodeInto = %v, want ErrHashMismatch", err)
+		}
+	})
+}
+
+// A file larger than maxBufferedEntry is spooled to disk while it is
+// hashed, rather than held in memory.
+func TestChecksumsRoundTrip(t *testing.T) {
+	big := make([]byte, maxBufferedEntry+4096)
+	rand.New(rand.NewSource(1)).Read(big)
+
+	for _, algo := range []DirectoryCompressionAlgorithm{ZIP, TAR} {
+		for _, workers := range []int{1, 4} {
+			dir := t.TempDir()
+			src := filepath.Join(dir, "src")
+			tree := map[string]string{"big.bin": string(big), "small.txt": "small"}
+			writeTree(t, src, tree)
+
+			archive := filepath.Join(dir, "sums"+algo.extension())
+			if err := NewArchiver(algo, WithChecksums(), WithConcurrency(workers)).Encode(src, archive); err != nil {
+				t.Fatal(err)
+			}
+			out := filepath.Join(dir, "out")
+			if err := DecodeInto(archive, out, algo); err != nil {
+				t.Fatalf("%s, %d workers: %v", algo.extension(), workers, err)
+			}
+			assertTree(t, filepath.Join(out, src), tree)
+		}
+	}
+}
+
+func TestListSkipsZipChecksums(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	writeTree(t, src, map[string]string{"a.txt": "a"})
+	archive := filepath.Join(dir, "sums.zip")
+	if err := NewArchiver(ZIP, WithChecksums()).Encode(src, archive); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ListArchive(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name != filepath.ToSlash(filepath.Join(src, "a.txt")) {
+		t.Errorf("ListArchive = %v, want only a.txt", entries)
+	}
+
+	size, err := UncompressedSize(archive, ZIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 1 {
+		t.Errorf("UncompressedSize = %d, want 1", size)
+	}
+}
@@ -0,0 +1,101 @@
+package kognit
+
+import (
+	"compress/bzip2"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// The standard library only ships a bzip2 reader.
+var ErrBZIP2Encode = errors.New("bzip2 archives can be decoded but not encoded")
+
+// compressor wraps w in the stream compression used around the tar-based
+// archives. The gzip header records name, minus its .gz suffix, and the
+// time of the encode, like the gzip tool does.
+func (a DirectoryCompressionAlgorithm) compressor(w io.Writer, name string, opts ArchiveOptions) (io.WriteCloser, error) {
+	switch a {
+	case TAR:
+		level := gzip.DefaultCompression
+		if opts.Level != 0 {
+			level = opts.Level
+		}
+		gw, err := gzip.NewWriterLevel(w, level)
+		if err != nil {
+			return nil, err
+		}
+		gw.Name = strings.TrimSuffix(name, ".gz")
+		gw.ModTime = time.Now()
+		return gw, nil
+	case ZSTD:
+		level := zstd.SpeedDefault
+		if opts.Level != 0 {
+			level = zstd.EncoderLevelFromZstd(opts.Level)
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(level))
+	case BZIP2:
+		return nil, ErrBZIP2Encode
+	}
+	return nil, a.unsupported()
+}
+
+// streamName names the compression wrapped around a tar-based archive.
+func (a DirectoryCompressionAlgorithm) streamName() string {
+	switch a {
+	case TAR:
+		return "gzip"
+	case ZSTD:
+		return "zstd"
+	case BZIP2:
+		return "bzip2"
+	}
+	return ""
+}
+
+var ErrLevelUnsupported = errors.New("algorithm does not support compression levels")
+
+// checkLevel validates a compression level against the range the algorithm
+// accepts. Zero always means the default.
+func (a DirectoryCompressionAlgorithm) checkLevel(level int) error {
+	if level == 0 {
+		return nil
+	}
+
+	min, max := 0, 0
+	switch a {
+	case ZIP, TAR:
+		min, max = flate.BestSpeed, flate.BestCompression
+	case ZSTD:
+		min, max = 1, 22
+	default:
+		return fmt.Errorf("level %d: %w", level, ErrLevelUnsupported)
+	}
+
+	if level < min || level > max {
+		return fmt.Errorf("invalid level %d, must be between %d and %d", level, min, max)
+	}
+	return nil
+}
+
+func (a DirectoryCompressionAlgorithm) decompressor(r io.Reader) (io.ReadCloser, error) {
+	switch a {
+	case TAR:
+		return gzip.NewReader(r)
+	case ZSTD:
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	case BZIP2:
+		return ioutil.NopCloser(bzip2.NewReader(r)), nil
+	}
+	return nil, a.unsupported()
+}
@@ -0,0 +1,186 @@
+package kognit
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tree.tar.bz2 is written by testdata/make_bzip2.py.
+func TestDecodeBZIP2Fixture(t *testing.T) {
+	fixture := filepath.Join("testdata", "tree.tar.bz2")
+	want := map[string]string{
+		"tree/a.txt":     "alpha\n",
+		"tree/sub/b.txt": strings.Repeat("bravo ", 100),
+	}
+
+	for name, decode := range map[string]func(src, dest string) error{
+		"BZIP2": func(src, dest string) error { return DecodeInto(src, dest, BZIP2) },
+		"auto":  DecodeAuto,
+	} {
+		t.Run(name, func(t *testing.T) {
+			out := t.TempDir()
+			if err := decode(fixture, out); err != nil {
+				t.Fatal(err)
+			}
+			assertTree(t, out, want)
+
+			if target, err := os.Readlink(filepath.Join(out, "tree", "link")); err != nil || target != "a.txt" {
+				t.Errorf("tree/link -> %q, %v; want a symlink to a.txt", target, err)
+			}
+			info, err := os.Stat(filepath.Join(out, "tree", "sub"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0750 || !info.ModTime().Equal(time.Unix(1600000000, 0)) {
+				t.Errorf("tree/sub has mode %v and mtime %v", info.Mode().Perm(), info.ModTime())
+			}
+		})
+	}
+}
+
+func TestEncodeBZIP2Unsupported(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"src/a.txt": "alpha"})
+	err := NewArchiver(BZIP2).Encode(filepath.Join(dir, "src"), filepath.Join(dir, "out"+BZIP2.extension()))
+	if !errors.Is(err, ErrBZIP2Encode) {
+		t.Errorf("Encode = %v, want ErrBZIP2Encode", err)
+	}
+}
+
+// wordSoup is about n bytes of words from a fixed seed: compressible like
+// text, but not so regular that every compressor reaches the same size.
+func wordSoup(n int) string {
+	words := strings.Fields("lorem ipsum dolor sit amet consectetur adipiscing elit sed do eiusmod tempor incididunt ut labore")
+	rng := rand.New(rand.NewSource(1))
+	var b strings.Builder
+	for b.Len() < n {
+		b.WriteString(words[rng.Intn(len(words))])
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
+
+func TestZSTDRoundTripAndSize(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	// Repeated at a distance beyond gzip's 32 KiB window but well within
+	// zstd's, as in logs or copied files.
+	text := strings.Repeat(wordSoup(64<<10), 4)
+	files := map[string]string{"text.txt": text, "sub/small.txt": "small"}
+	writeTree(t, src, files)
+
+	sizes := map[string]int64{}
+	for _, tt := range []struct {
+		algo  DirectoryCompressionAlgorithm
+		level int
+	}{
+		{TAR, 0}, {TAR, 9}, {ZSTD, 0}, {ZSTD, 19},
+	} {
+		archive := filepath.Join(dir, fmt.Sprintf("level%d%s", tt.level, tt.algo.extension()))
+		a := NewArchiver(tt.algo, WithLevel(tt.level))
+		if err := a.Encode(src, archive); err != nil {
+			t.Fatal(err)
+		}
+		out := archive + ".out"
+		if err := a.Decode(archive, out); err != nil {
+			t.Fatal(err)
+		}
+		assertTree(t, filepath.Join(out, src), files)
+
+		info, err := os.Stat(archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes[filepath.Base(archive)] = info.Size()
+	}
+	t.Logf("archive sizes: %v", sizes)
+
+	if sizes["level19.tar.zst"] >= sizes["level9.tar.gz"] {
+		t.Errorf("zstd level 19 (%d bytes) is no smaller than gzip level 9 (%d bytes)", sizes["level19.tar.zst"], sizes["level9.tar.gz"])
+	}
+	if sizes["level0.tar.zst"] >= sizes["level0.tar.gz"] {
+		t.Errorf("default zstd (%d bytes) is no smaller than default gzip (%d bytes)", sizes["level0.tar.zst"], sizes["level0.tar.gz"])
+	}
+
+	if err := NewArchiver(ZSTD, WithLevel(23)).Encode(src, filepath.Join(dir, "bad.tar.zst")); err == nil {
+		t.Error("zstd level 23 was accepted")
+	}
+}
+
+func TestLevelsChangeOutput(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	writeTree(t, src, map[string]string{"text.txt": wordSoup(256 << 10)})
+
+	for _, tt := range []struct {
+		algo           DirectoryCompressionAlgorithm
+		fast, best     int
+		tooLow, tooBig int
+	}{
+		{ZIP, 1, 9, -1, 10},
+		{TAR, 1, 9, -1, 10},
+		{ZSTD, 1, 22, -1, 23},
+	} {
+		size := func(level int) int64 {
+			archive := filepath.Join(dir, fmt.Sprintf("level%d%s", level, tt.algo.extension()))
+			if err := NewArchiver(tt.algo, WithLevel(level)).Encode(src, archive); err != nil {
+				t.Fatal(err)
+			}
+			info, err := os.Stat(archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return info.Size()
+		}
+		if fast, best := size(tt.fast), size(tt.best); best >= fast {
+			t.Errorf("%s: level %d gave %d bytes, level %d gave %d", tt.algo.extension(), tt.best, best, tt.fast, fast)
+		}
+
+		for _, level := range []int{tt.tooLow, tt.tooBig} {
+			err := NewArchiver(tt.algo, WithLevel(level)).Encode(src, filepath.Join(dir, "bad"+tt.algo.extension()))
+			if err == nil || !strings.Contains(err.Error(), "invalid level") {
+				t.Errorf("%s: level %d gave %v, want an invalid level error", tt.algo.extension(), level, err)
+			}
+		}
+	}
+}
+
+func TestGzipHeader(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "photos")
+	writeTree(t, src, map[string]string{"a.txt": "alpha"})
+
+	before := time.Now().Truncate(time.Second)
+	for archive, encode := range map[string]func() error{
+		filepath.Join(dir, "backup.tar.gz"): func() error { return NewArchiver(TAR).Encode(src, filepath.Join(dir, "backup.tar.gz")) },
+		src + ".tar.gz":                     func() error { return TAR.Encode(src) },
+	} {
+		if err := encode(); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := strings.TrimSuffix(filepath.Base(archive), ".gz")
+		if gr.Name != want {
+			t.Errorf("%s: gzip name %q, want %q", filepath.Base(archive), gr.Name, want)
+		}
+		if gr.ModTime.Before(before) || gr.ModTime.After(time.Now()) {
+			t.Errorf("%s: gzip mtime %v is not the encode time", filepath.Base(archive), gr.ModTime)
+		}
+		gr.Close()
+		f.Close()
+	}
+}
@@ -0,0 +1,253 @@
+package kognit
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// zipDefaultLevel is the deflate level archive/zip uses on its own, so
+// entries compressed ahead of time match the ones it would produce.
+const zipDefaultLevel = 5
+
+// maxBufferedEntry is the largest file whose content is held in memory while
+// archiving. The parallel writers stream bigger files in place instead of
+// loading them ahead, and hashTarData spools them to a temporary file, so
+// memory stays bounded by the number of workers whatever the file sizes.
+const maxBufferedEntry = 1 << 20
+
+type payload struct {
+	info       os.FileInfo
+	data       []byte
+	compressed []byte
+	err        error
+	// large is set instead of data for files over maxBufferedEntry, which
+	// the writer reads itself.
+	large bool
+}
+
+// payloadLoader reads files on up to workers goroutines ahead of a single
+// archive writer, which takes them back in file order. A slot is only freed
+// once the writer is done with a payload, bounding how many are in memory.
+type payloadLoader struct {
+	results []chan *payload
+	slots   chan struct{}
+	done    chan struct{}
+}
+
+func newPayloadLoader(files []archiveFile, workers int, compress func(archiveFile, []byte) ([]byte, error)) *payloadLoader {
+	l := &payloadLoader{
+		results: make([]chan *payload, len(files)),
+		slots:   make(chan struct{}, workers),
+		done:    make(chan struct{}),
+	}
+	for i := range l.results {
+		l.results[i] = make(chan *payload, 1)
+	}
+
+	go func() {
+		for i, file := range files {
+			select {
+			case l.slots <- struct{}{}:
+			case <-l.done:
+				return
+			}
+			go func(i int, file archiveFile) {
+				l.results[i] <- loadPayload(file, compress)
+			}(i, file)
+		}
+	}()
+	return l
+}
+
+func (l *payloadLoader) get(i int) *payload {
+	return <-l.results[i]
+}
+
+func (l *payloadLoader) release() {
+	<-l.slots
+}
+
+func (l *payloadLoader) close() {
+	close(l.done)
+}
+
+func loadPayload(file archiveFile, compress func(archiveFile, []byte) ([]byte, error)) *payload {
+	p := &payload{}
+
+	if file.symlink != "" {
+		p.info, p.err = os.Lstat(file.path)
+		return p
+	}
+
+	// Dedup links only reach the tar writer, which stores them without data.
+	p.info, p.err = os.Stat(file.path)
+	if p.err != nil || file.link != "" {
+		return p
+	}
+	if p.info.Size() > maxBufferedEntry {
+		p.large = true
+		return p
+	}
+
+	if p.data, p.err = ioutil.ReadFile(file.path); p.err != nil {
+		return p
+	}
+	if compress != nil {
+		p.compressed, p.err = compress(file, p.data)
+	}
+	return p
+}
+
+func writeZipArchiveParallel(w io.Writer, files []archiveFile, opts ArchiveOptions) error {
+	level := opts.Level
+	if level == 0 {
+		level = zipDefaultLevel
+	}
+
+	// The zip writer still computes sizes and the CRC from the plain bytes,
+	// but its compressor hands over the data deflated by the workers. Large
+	// files are deflated as they are written, as in the serial writer.
+	var current []byte
+	streaming := false
+	zipWriter := zip.NewWriter(w)
+	zipWriter.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		if streaming {
+			return flate.NewWriter(out, level)
+		}
+		return &precompressedWriter{w: out, data: current}, nil
+	})
+
+	loader := newPayloadLoader(files, opts.Concurrency, func(file archiveFile, data []byte) ([]byte, error) {
+		if zipMethod(file.name) != zip.Deflate {
+			return nil, nil
+		}
+		return deflate(data, level)
+	})
+	defer loader.close()
+
+	sums := newZipChecksums(opts)
+	for i, file := range files {
+		p := loader.get(i)
+		if vanished(file, p.err, opts) {
+			loader.release()
+			continue
+		}
+		if p.err != nil {
+			return p.err
+		}
+
+		if p.large {
+			streaming = true
+			err := addFileToZip(zipWriter, file, opts, sums)
+			streaming = false
+			if err != nil {
+				return err
+			}
+			loader.release()
+			continue
+		}
+
+		current = p.compressed
+		var data io.Reader = bytes.NewReader(p.data)
+		if file.symlink == "" {
+			data = sums.tee(file.name, data)
+		}
+		if err := addToZip(zipWriter, file, p.info, data); err != nil {
+			return err
+		}
+		loader.release()
+	}
+	if err := sums.write(zipWriter); err != nil {
+		return err
+	}
+	return zipWriter.Close()
+}
+
+func writeTarArchiveParallel(w io.Writer, files []archiveFile, opts ArchiveOptions) error {
+	tarWriter := tar.NewWriter(w)
+
+	loader := newPayloadLoader(files, opts.Concurrency, nil)
+	defer loader.close()
+	targets := dedupTargets{}
+
+	for i, file := range files {
+		p := loader.get(i)
+		if vanished(file, p.err, opts) {
+			loader.release()
+			continue
+		}
+		if p.err != nil {
+			return p.err
+		}
+
+		key := targets.resolve(&file)
+		if p.large || files[i].link != "" && file.link == "" {
+			// The loader left this file's data for the writer: it is too
+			// large to buffer, or it was a duplicate whose first copy
+			// vanished, so it is now stored in full.
+			loader.release()
+			ok, err := addFileToTar(tarWriter, file, opts)
+			if err != nil {
+				return err
+			}
+			if ok {
+				targets.stored(key, file)
+			}
+			continue
+		}
+
+		data := tarData(file, bytes.NewReader(p.data), p.info, opts)
+		if opts.Checksums && file.link == "" && file.symlink == "" {
+			hashed, err := hashTarData(&file, data, p.info)
+			if err != nil {
+				return err
+			}
+			err = addToTar(tarWriter, file, p.info, hashed, opts)
+			hashed.Close()
+			if err != nil {
+				return err
+			}
+		} else if err := addToTar(tarWriter, file, p.info, data, opts); err != nil {
+			return err
+		}
+		targets.stored(key, file)
+		loader.release()
+	}
+	return tarWriter.Close()
+}
+
+func deflate(data []byte, level int) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	fw, err := flate.NewWriter(buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := fw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// precompressedWriter discards what is written to it and emits data, which
+// is already the compressed form of those bytes, on Close.
+type precompressedWriter struct {
+	w    io.Writer
+	data []byte
+}
+
+func (p *precompressedWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (p *precompressedWriter) Close() error {
+	_, err := p.w.Write(p.data)
+	return err
+}
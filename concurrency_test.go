@@ -0,0 +1,192 @@
+package kognit
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// archiveBytes encodes src with opts and returns the archive. Tar output is
+// returned decompressed, since the gzip header records the encode time.
+func archiveBytes(t *testing.T, algo DirectoryCompressionAlgorithm, src string, opts ...Option) []byte {
+	t.Helper()
+	dest := filepath.Join(t.TempDir(), "out"+algo.extension())
+	if err := NewArchiver(algo, opts...).Encode(src, dest); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if algo != TAR {
+		return data
+	}
+
+	gr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := ioutil.ReadAll(gr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return plain
+}
+
+func TestConcurrencyOutputIsIdentical(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src")
+	files := map[string]string{
+		"a.txt":       strings.Repeat("alpha ", 1000),
+		"copy.txt":    strings.Repeat("alpha ", 1000),
+		"sub/b.txt":   "bravo",
+		"sub/c.txt":   strings.Repeat("alpha ", 1000),
+		"photo.jpg":   "not really a jpeg",
+		"empty.txt":   "",
+		"sub/d/e.txt": strings.Repeat("echo", 5000),
+		// Over maxBufferedEntry, so streamed rather than loaded ahead.
+		"big.txt":     strings.Repeat("foxtrot ", maxBufferedEntry/4),
+		"big-dup.txt": strings.Repeat("foxtrot ", maxBufferedEntry/4),
+	}
+	writeTree(t, src, files)
+
+	for _, algo := range []DirectoryCompressionAlgorithm{ZIP, TAR} {
+		for _, dedup := range []bool{false, true} {
+			opts := ArchiveOptions{Dedup: dedup}
+			serial := archiveBytes(t, algo, src, WithOptions(opts))
+			for _, workers := range []int{2, 4, 16} {
+				opts.Concurrency = workers
+				parallel := archiveBytes(t, algo, src, WithOptions(opts))
+				if !bytes.Equal(serial, parallel) {
+					t.Errorf("%s dedup=%v: output with %d workers differs from serial output", algo.extension(), dedup, workers)
+				}
+
+				dest := filepath.Join(t.TempDir(), "extracted")
+				archive := filepath.Join(t.TempDir(), "out"+algo.extension())
+				if err := NewArchiver(algo, WithOptions(opts)).Encode(src, archive); err != nil {
+					t.Fatal(err)
+				}
+				if err := NewArchiver(algo).Decode(archive, dest); err != nil {
+					t.Fatalf("%s dedup=%v workers=%d: Decode: %v", algo.extension(), dedup, workers, err)
+				}
+				assertTree(t, filepath.Join(dest, src), files)
+			}
+		}
+	}
+}
+
+func TestLoadPayloadLeavesLargeFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"small.txt": "small", "big.txt": strings.Repeat("x", maxBufferedEntry+1)})
+
+	small := loadPayload(archiveFile{path: filepath.Join(dir, "small.txt")}, nil)
+	if small.err != nil || small.large || string(small.data) != "small" {
+		t.Errorf("small file: data %q, large %v, err %v; want it loaded", small.data, small.large, small.err)
+	}
+	compress := func(file archiveFile, data []byte) ([]byte, error) { return deflate(data, zipDefaultLevel) }
+	big := loadPayload(archiveFile{path: filepath.Join(dir, "big.txt")}, compress)
+	if big.err != nil || !big.large || big.data != nil || big.compressed != nil {
+		t.Errorf("big file: %d bytes loaded, large %v, err %v; want it left to the writer", len(big.data), big.large, big.err)
+	}
+}
+
+func TestDedupFirstCopyVanished(t *testing.T) {
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{"b.txt": "same", "c.txt": "same"})
+
+	// a.txt was deduplicated against but is gone by the time it is written.
+	files := []archiveFile{
+		{path: filepath.Join(src, "a.txt"), name: "a.txt"},
+		{path: filepath.Join(src, "b.txt"), name: "b.txt", link: "a.txt"},
+		{path: filepath.Join(src, "c.txt"), name: "c.txt", link: "a.txt"},
+	}
+	opts := ArchiveOptions{SkipVanished: true, Concurrency: 4}
+
+	for name, write := range map[string]func(io.Writer, []archiveFile, ArchiveOptions) error{
+		"serial":   writeTarArchive,
+		"parallel": writeTarArchiveParallel,
+	} {
+		t.Run(name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			if err := write(buf, append([]archiveFile(nil), files...), opts); err != nil {
+				t.Fatal(err)
+			}
+
+			r := tar.NewReader(buf)
+			var got []string
+			for {
+				header, err := r.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				data, err := ioutil.ReadAll(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, header.Name+" "+string(header.Typeflag)+" "+header.Linkname+" "+string(data))
+			}
+
+			want := []string{
+				"b.txt " + string(tar.TypeReg) + "  same",
+				"c.txt " + string(tar.TypeLink) + " b.txt ",
+			}
+			if strings.Join(got, "\n") != strings.Join(want, "\n") {
+				t.Errorf("entries:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+			}
+		})
+	}
+}
+
+func TestSharedArchiverConcurrentCalls(t *testing.T) {
+	dir := t.TempDir()
+	const workers = 8
+	trees := make([]map[string]string, workers)
+	for i := range trees {
+		trees[i] = map[string]string{
+			"id.txt":      fmt.Sprintf("tree %d", i),
+			"sub/a.txt":   strings.Repeat(fmt.Sprintf("alpha %d ", i), 500),
+			"sub/dup.txt": strings.Repeat(fmt.Sprintf("alpha %d ", i), 500),
+			"sub/d/e.txt": "shared",
+		}
+		writeTree(t, filepath.Join(dir, "src", fmt.Sprint(i)), trees[i])
+	}
+
+	for _, algo := range []DirectoryCompressionAlgorithm{ZIP, TAR} {
+		t.Run(algo.extension(), func(t *testing.T) {
+			a := NewArchiver(algo, WithDedup(), WithChecksums(), WithConcurrency(4))
+			var wg sync.WaitGroup
+			for i := 0; i < workers; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					src := filepath.Join(dir, "src", fmt.Sprint(i))
+					archive := filepath.Join(dir, fmt.Sprintf("%d%s", i, algo.extension()))
+					out := filepath.Join(dir, "out"+algo.extension(), fmt.Sprint(i))
+					if err := a.Encode(src, archive); err != nil {
+						t.Error(err)
+						return
+					}
+					if err := a.Decode(archive, out); err != nil {
+						t.Error(err)
+						return
+					}
+				}(i)
+			}
+			wg.Wait()
+
+			for i := 0; i < workers; i++ {
+				src := filepath.Join(dir, "src", fmt.Sprint(i))
+				assertTree(t, filepath.Join(dir, "out"+algo.extension(), fmt.Sprint(i), src), trees[i])
+			}
+		})
+	}
+}
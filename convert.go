@@ -0,0 +1,297 @@
+package kognit
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ConvertArchive rewrites the from archive at src as a to archive at dest.
+// Entries are streamed from one to the other without being extracted, and
+// keep their names, modes, modification times and checksums. Tar hard links
+// become full copies in a zip, for which the source's headers are read once
+// beforehand to find their targets. Converting to BZIP2 returns
+// ErrBZIP2Encode.
+func ConvertArchive(src, dest string, from, to DirectoryCompressionAlgorithm) error {
+	if to == BZIP2 {
+		return ErrBZIP2Encode
+	}
+	if to.extension() == "" {
+		return to.unsupported()
+	}
+
+	f, err := createPending(dest)
+	if err != nil {
+		return err
+	}
+	defer f.discard()
+
+	buf := bufio.NewWriter(f)
+
+	var sink entrySink
+	if to == ZIP {
+		zs := &zipSink{w: zip.NewWriter(buf), sums: newZipChecksums(ArchiveOptions{Checksums: true})}
+		if from != ZIP {
+			if zs.targets, err = from.linkTargets(src); err != nil {
+				return err
+			}
+		}
+		defer zs.removeSpools()
+		sink = zs
+	} else {
+		cw, err := to.compressor(buf, filepath.Base(dest), ArchiveOptions{})
+		if err != nil {
+			return err
+		}
+		sink = &tarSink{w: tar.NewWriter(cw), cw: cw}
+	}
+
+	if err := from.readEntries(src, sink.add); err != nil {
+		return err
+	}
+	if err := sink.close(); err != nil {
+		return err
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+	return f.commit()
+}
+
+// readEntries calls fn with every entry of the archive at src, described
+// as a tar header whatever the format. Stored checksums are verified as the
+// data is read.
+func (a DirectoryCompressionAlgorithm) readEntries(src string, fn func(*tar.Header, io.Reader) error) error {
+	switch a {
+	case ZIP:
+		return readZipEntries(src, fn)
+	case TAR, ZSTD, BZIP2:
+		return a.readTarEntries(src, fn)
+	}
+	return a.unsupported()
+}
+
+func (a DirectoryCompressionAlgorithm) readTarEntries(src string, fn func(*tar.Header, io.Reader) error) error {
+	f, _, err := openArchive(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dr, err := a.decompressor(f)
+	if err != nil {
+		return err
+	}
+	defer dr.Close()
+
+	r := tar.NewReader(dr)
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(header, verifyHash(r, header.Name, header.PAXRecords[paxSHA256])); err != nil {
+			return err
+		}
+	}
+}
+
+func readZipEntries(src string, fn func(*tar.Header, io.Reader) error) error {
+	archive, size, err := openArchive(src)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	r, err := zip.NewReader(archive, size)
+	if err != nil {
+		return err
+	}
+
+	sums, err := readZipChecksums(r, archive, "")
+	if err != nil {
+		return err
+	}
+
+	for _, f := range r.File {
+		if sums != nil && f.Name == zipChecksumsName {
+			continue
+		}
+		if err := readZipEntry(f, archive, sums[f.Name], fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readZipEntry(f *zip.File, archive io.ReaderAt, sum string, fn func(*tar.Header, io.Reader) error) error {
+	rc, err := openZipEntry(f, archive, "")
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	info := f.FileInfo()
+	link := ""
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := ioutil.ReadAll(rc)
+		if err != nil {
+			return err
+		}
+		link = string(target)
+	}
+
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	header.Name = f.Name
+	header.ModTime = f.Modified
+	if sum != "" {
+		header.PAXRecords = map[string]string{paxSHA256: sum}
+	}
+	return fn(header, verifyHash(rc, f.Name, sum))
+}
+
+type entrySink interface {
+	add(header *tar.Header, data io.Reader) error
+	close() error
+}
+
+type tarSink struct {
+	w  *tar.Writer
+	cw io.WriteCloser
+}
+
+func (s *tarSink) add(header *tar.Header, data io.Reader) error {
+	if err := s.w.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := io.Copy(s.w, data)
+	return err
+}
+
+func (s *tarSink) close() error {
+	if err := s.w.Close(); err != nil {
+		return err
+	}
+	return s.cw.Close()
+}
+
+// zipSink writes converted entries to a zip. A checksum list is only added
+// when the source carried checksums.
+type zipSink struct {
+	w       *zip.Writer
+	sums    *zipChecksums
+	hadSums bool
+
+	// Zip has no hard links, so the content of each entry a tar hard link
+	// points at is spooled as it goes by, to be copied again for the link.
+	targets map[string]bool
+	spools  map[string]*os.File
+}
+
+func (s *zipSink) add(header *tar.Header, data io.Reader) error {
+	if header.PAXRecords[paxSHA256] != "" {
+		s.hadSums = true
+	}
+
+	info := header.FileInfo()
+	mode := info.Mode()
+	if header.Typeflag != tar.TypeLink && !mode.IsRegular() && !mode.IsDir() && mode&os.ModeSymlink == 0 {
+		return fmt.Errorf("%s: %w", header.Name, ErrUnknownEntryType)
+	}
+
+	zh, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	zh.Name = header.Name
+	zh.Modified = header.ModTime
+
+	switch {
+	case mode.IsDir():
+		if !strings.HasSuffix(zh.Name, "/") {
+			zh.Name += "/"
+		}
+		zh.Method = zip.Store
+		_, err := s.w.CreateHeader(zh)
+		return err
+	case mode&os.ModeSymlink != 0:
+		zh.Method = zip.Store
+		data = strings.NewReader(header.Linkname)
+	default:
+		zh.Method = zipMethod(header.Name)
+	}
+
+	writer, err := s.w.CreateHeader(zh)
+	if err != nil {
+		return err
+	}
+
+	if header.Typeflag == tar.TypeLink {
+		spool := s.spools[header.Linkname]
+		if spool == nil {
+			return fmt.Errorf("%s: link target %s: %w", header.Name, header.Linkname, ErrEntryNotFound)
+		}
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		_, err := io.Copy(writer, s.sums.tee(header.Name, spool))
+		return err
+	}
+	if mode&os.ModeSymlink == 0 {
+		data = s.sums.tee(header.Name, data)
+	}
+	if s.targets[header.Name] && s.spools[header.Name] == nil {
+		spool, err := ioutil.TempFile("", "kognit-*.spool")
+		if err != nil {
+			return err
+		}
+		if s.spools == nil {
+			s.spools = map[string]*os.File{}
+		}
+		s.spools[header.Name] = spool
+		data = io.TeeReader(data, spool)
+	}
+	_, err = io.Copy(writer, data)
+	return err
+}
+
+func (s *zipSink) removeSpools() {
+	for _, spool := range s.spools {
+		spooledFile{spool}.Close()
+	}
+}
+
+func (s *zipSink) close() error {
+	if s.hadSums {
+		if err := s.sums.write(s.w); err != nil {
+			return err
+		}
+	}
+	return s.w.Close()
+}
+
+// linkTargets returns the names the hard links in the tar-based archive at
+// src point at. Reading the headers once up front costs one pass over
+// src, where rescanning it for each link would cost one per link.
+func (a DirectoryCompressionAlgorithm) linkTargets(src string) (map[string]bool, error) {
+	targets := map[string]bool{}
+	err := a.readTarEntries(src, func(header *tar.Header, _ io.Reader) error {
+		if header.Typeflag == tar.TypeLink {
+			targets[header.Linkname] = true
+		}
+		return nil
+	})
+	return targets, err
+}
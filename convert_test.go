@@ -0,0 +1,66 @@
+package kognit
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConvertZipToTarAndBack(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	tree := map[string]string{
+		"a.txt":         "alpha",
+		"sub/b.txt":     strings.Repeat("bravo ", 500),
+		"sub/deep/c.md": "charlie",
+		"empty.txt":     "",
+	}
+	writeTree(t, src, tree)
+
+	zipped := filepath.Join(dir, "in.zip")
+	if err := NewArchiver(ZIP).Encode(src, zipped); err != nil {
+		t.Fatal(err)
+	}
+	tarred := filepath.Join(dir, "mid.tar.gz")
+	if err := ConvertArchive(zipped, tarred, ZIP, TAR); err != nil {
+		t.Fatal(err)
+	}
+	back := filepath.Join(dir, "back.zip")
+	if err := ConvertArchive(tarred, back, TAR, ZIP); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, step := range []struct {
+		archive string
+		algo    DirectoryCompressionAlgorithm
+	}{{tarred, TAR}, {back, ZIP}} {
+		out := filepath.Join(t.TempDir(), "out")
+		if err := DecodeInto(step.archive, out, step.algo); err != nil {
+			t.Fatal(err)
+		}
+		assertTree(t, filepath.Join(out, src), tree)
+	}
+}
+
+func TestConvertDedupTarToZip(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	same := strings.Repeat("same ", 100)
+	tree := map[string]string{"a.txt": same, "b.txt": same, "c.txt": same, "d.txt": "different"}
+	writeTree(t, src, tree)
+
+	tarred := filepath.Join(dir, "dedup.tar.gz")
+	if err := NewArchiver(TAR, WithDedup(), WithChecksums()).Encode(src, tarred); err != nil {
+		t.Fatal(err)
+	}
+	zipped := filepath.Join(dir, "out.zip")
+	if err := ConvertArchive(tarred, zipped, TAR, ZIP); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(dir, "out")
+	if err := DecodeInto(zipped, out, ZIP); err != nil {
+		t.Fatal(err)
+	}
+	assertTree(t, filepath.Join(out, src), tree)
+}
@@ -3,116 +3,362 @@ package kognit
 import (
 	"archive/tar"
 	"archive/zip"
-	"compress/gzip"
+	"bufio"
+	"bytes"
+	"compress/flate"
 	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"math"
 	"os"
+	"os/user"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const (
 	ZIP DirectoryCompressionAlgorithm = iota
 	TAR
+	ZSTD
+	BZIP2
 )
 
+// archiveFile pairs a file on disk with the name it is stored under.
+type archiveFile struct {
+	path string
+	name string
+	// link names an earlier entry with identical content.
+	link string
+	// xattrs holds the file's extended attributes when they are archived.
+	xattrs map[string]string
+	// sha256 is the hex digest of the file's content when Checksums is set.
+	sha256 string
+	// symlink is the target of a symlink stored as a link.
+	symlink string
+}
+
 func (a DirectoryCompressionAlgorithm) Encode(src string) error {
-	dest := src
+	return NewArchiver(a).Encode(src, src+a.extension())
+}
 
-	switch a {
-	case ZIP:
-		dest += ".zip"
-		if err := encodeZipArchive(src, dest); err != nil {
+func (a DirectoryCompressionAlgorithm) EncodeWithOptions(src string, opts ArchiveOptions) error {
+	return NewArchiver(a, WithOptions(opts)).Encode(src, src+a.extension())
+}
+
+// dirArchiveFiles lists the files and symlinks under src, stored under
+// their walked path. With FollowSymlinks, symlinks are replaced by what
+// they point to: a file's content, or a directory's files under the link's
+// name. A symlink to src or one of the directories it is inside of would
+// repeat forever, so it is logged and left out.
+func dirArchiveFiles(src string, opts ArchiveOptions) ([]archiveFile, error) {
+	if err := checkSource(src); err != nil {
+		return nil, err
+	}
+
+	root, err := filepath.EvalSymlinks(src)
+	if err != nil {
+		return nil, err
+	}
+	w := &sourceWalk{src: src, opts: opts, dirs: []string{root}}
+	if err := w.walk(root, src); err != nil {
+		return nil, err
+	}
+	return w.files, nil
+}
+
+type sourceWalk struct {
+	src   string
+	opts  ArchiveOptions
+	files []archiveFile
+	// dirs holds the real paths of src and of the followed directories
+	// being walked.
+	dirs []string
+}
+
+// walk lists the tree at root, naming its entries as if root were name.
+func (s *sourceWalk) walk(root, name string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
 			return err
 		}
-	case TAR:
-		dest += ".tar.gz"
-		if err := encodeTarArchive(src, dest); err != nil {
+
+		entryName := name
+		if path != root {
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			entryName = filepath.Join(name, rel)
+		}
+
+		if keep, err := walkFilter(s.src, entryName, info, s.opts); !keep {
 			return err
 		}
-	}
-	return nil
+		switch {
+		case info.Mode().IsRegular():
+			s.files = append(s.files, archiveFile{path: path, name: entryName})
+		case info.Mode()&os.ModeSymlink != 0:
+			return s.symlink(path, entryName)
+		}
+		return nil
+	})
 }
 
-func encodeZipArchive(src, dest string) error {
-	files, err := allDirFiles(src)
+func (s *sourceWalk) symlink(path, name string) error {
+	if !s.opts.FollowSymlinks {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		s.files = append(s.files, archiveFile{path: path, name: name, symlink: target})
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		logf("kognit: skipping %s: dangling symlink", path)
+		return nil
+	}
 	if err != nil {
 		return err
 	}
+	if info.Mode().IsRegular() {
+		if keep, _ := walkFilter(s.src, name, info, s.opts); keep {
+			s.files = append(s.files, archiveFile{path: path, name: name})
+		}
+		return nil
+	}
+	if !info.IsDir() {
+		return nil
+	}
 
-	f, err := os.Create(dest)
+	target, err := filepath.EvalSymlinks(path)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	parent, err := filepath.EvalSymlinks(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	for _, dir := range append([]string{parent}, s.dirs...) {
+		if isWithin(target, dir) {
+			logf("kognit: skipping %s: symlink loop to %s", path, target)
+			return nil
+		}
+	}
 
-	zipWriter := zip.NewWriter(f)
-	defer zipWriter.Close()
+	s.dirs = append(s.dirs, target)
+	defer func() { s.dirs = s.dirs[:len(s.dirs)-1] }()
+	return s.walk(target, name)
+}
 
-	for _, file := range files {
-		if err = addToZip(zipWriter, file); err != nil {
+// isWithin reports whether path is dir or lies below it.
+func isWithin(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator))
+}
+
+// EncodeMany archives several files or directories into dest. Each source is
+// stored under its own base name, suffixed when two sources share one.
+func EncodeMany(srcs []string, dest string, algo DirectoryCompressionAlgorithm) error {
+	entries := []archiveFile{}
+	prefixes := map[string]bool{}
+
+	for _, src := range srcs {
+		prefix := filepath.Base(src)
+		for i := 2; prefixes[prefix]; i++ {
+			prefix = fmt.Sprintf("%s_%d", filepath.Base(src), i)
+		}
+		prefixes[prefix] = true
+
+		files, err := dirArchiveFiles(src, ArchiveOptions{})
+		if err != nil {
 			return err
 		}
+
+		for _, file := range files {
+			rel, err := filepath.Rel(src, file.name)
+			if err != nil {
+				return err
+			}
+			file.name = filepath.Join(prefix, rel)
+			entries = append(entries, file)
+		}
 	}
-	return nil
+	return algo.encode(entries, dest, ArchiveOptions{})
 }
 
-func addToZip(w *zip.Writer, filename string) error {
-	file, err := os.Open(filename)
-	if err != nil {
-		return err
+func (a DirectoryCompressionAlgorithm) extension() string {
+	switch a {
+	case ZIP:
+		return ".zip"
+	case TAR:
+		return ".tar.gz"
+	case ZSTD:
+		return ".tar.zst"
+	case BZIP2:
+		return ".tar.bz2"
 	}
-	defer file.Close()
+	return ""
+}
 
-	info, err := file.Stat()
-	if err != nil {
+func (a DirectoryCompressionAlgorithm) encode(files []archiveFile, dest string, opts ArchiveOptions) error {
+	if a == BZIP2 {
+		return ErrBZIP2Encode
+	}
+	if a.extension() == "" {
+		return a.unsupported()
+	}
+	if err := a.checkLevel(opts.Level); err != nil {
 		return err
 	}
 
-	header, err := zip.FileInfoHeader(info)
+	f, err := createOutput(dest, opts)
 	if err != nil {
 		return err
 	}
+	defer f.discard()
 
-	header.Name = filename
-	header.Method = zip.Deflate
+	// Zip has no hard links, so duplicates are stored in full there.
+	if opts.Dedup && a != ZIP {
+		if files, err = dedupArchiveFiles(files, opts); err != nil {
+			return err
+		}
+	}
+	if opts.Xattrs && a != ZIP {
+		for i := range files {
+			if files[i].symlink != "" {
+				continue
+			}
+			files[i].xattrs, err = readXattrs(files[i].path)
+			// The writer reports and skips a vanished file when it opens it.
+			if os.IsNotExist(err) && opts.SkipVanished {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
 
-	writer, err := w.CreateHeader(header)
-	if err != nil {
+	// Archive writers issue many small writes, especially for trees of tiny
+	// files, so batch them before they reach the file.
+	buf := bufio.NewWriter(f)
+
+	if err := a.encodeTo(buf, filepath.Base(dest), files, opts); err != nil {
 		return err
 	}
-
-	_, err = io.Copy(writer, file)
-	return err
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+	if opts.Durable {
+		if err := syncFile(f); err != nil {
+			return err
+		}
+	}
+	return f.commit()
 }
 
-func encodeTarArchive(src, dest string) error {
-	files, err := allDirFiles(src)
-	if err != nil {
-		return nil
+// encodeTo writes the archive to w, encrypting it when a passphrase is set.
+func (a DirectoryCompressionAlgorithm) encodeTo(w io.Writer, name string, files []archiveFile, opts ArchiveOptions) error {
+	if opts.Passphrase == "" {
+		return a.write(w, name, files, opts)
 	}
 
-	tarFile, err := os.Create(dest)
+	ew, err := newEncryptWriter(w, opts.Passphrase)
 	if err != nil {
 		return err
 	}
-	defer tarFile.Close()
+	if err := a.write(ew, name, files, opts); err != nil {
+		return err
+	}
+	return ew.Close()
+}
 
-	gzWriter := gzip.NewWriter(tarFile)
-	defer gzWriter.Close()
+// syncer is the part of *os.File that Durable relies on.
+type syncer interface {
+	Sync() error
+}
 
-	tarWriter := tar.NewWriter(gzWriter)
-	defer tarWriter.Close()
+// syncFile commits the archive, or the directory it was renamed into, to
+// stable storage. It is a variable so tests can see what Durable syncs.
+var syncFile = func(f syncer) error {
+	return f.Sync()
+}
 
-	for _, file := range files {
-		if err = addToTar(tarWriter, file); err != nil {
+// write encodes files to w. name is the archive's file name, recorded in the
+// gzip header of tar.gz output.
+func (a DirectoryCompressionAlgorithm) write(w io.Writer, name string, files []archiveFile, opts ArchiveOptions) error {
+	switch a {
+	case ZIP:
+		if opts.Concurrency > 1 {
+			return writeZipArchiveParallel(w, files, opts)
+		}
+		if err := writeZipArchive(w, files, opts); err != nil {
+			return err
+		}
+	case TAR, ZSTD, BZIP2:
+		cw, err := a.compressor(w, name, opts)
+		if err != nil {
+			return err
+		}
+		write := writeTarArchive
+		if opts.Concurrency > 1 {
+			write = writeTarArchiveParallel
+		}
+		if err := write(cw, files, opts); err != nil {
 			return err
 		}
+		return cw.Close()
+	default:
+		return a.unsupported()
 	}
 	return nil
 }
 
-func addToTar(w *tar.Writer, filename string) error {
-	file, err := os.Open(filename)
+func (a DirectoryCompressionAlgorithm) unsupported() error {
+	return fmt.Errorf("directory algorithm %d: %w", int(a), ErrUnsupportedAlgorithm)
+}
+
+func writeZipArchive(w io.Writer, files []archiveFile, opts ArchiveOptions) error {
+	zipWriter := zip.NewWriter(w)
+	if opts.Level != 0 {
+		zipWriter.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(out, opts.Level)
+		})
+	}
+
+	sums := newZipChecksums(opts)
+	for _, file := range files {
+		if err := addFileToZip(zipWriter, file, opts, sums); err != nil {
+			return err
+		}
+	}
+	if err := sums.write(zipWriter); err != nil {
+		return err
+	}
+	return zipWriter.Close()
+}
+
+func addFileToZip(w *zip.Writer, entry archiveFile, opts ArchiveOptions, sums *zipChecksums) error {
+	if entry.symlink != "" {
+		info, err := os.Lstat(entry.path)
+		if vanished(entry, err, opts) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return addToZip(w, entry, info, nil)
+	}
+
+	file, err := os.Open(entry.path)
+	if vanished(entry, err, opts) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
@@ -123,154 +369,1083 @@ func addToTar(w *tar.Writer, filename string) error {
 		return err
 	}
 
-	header, err := tar.FileInfoHeader(info, info.Name())
+	return addToZip(w, entry, info, sums.tee(entry.name, file))
+}
+
+func addToZip(w *zip.Writer, entry archiveFile, info os.FileInfo, data io.Reader) error {
+	header, err := zip.FileInfoHeader(info)
 	if err != nil {
 		return err
 	}
 
-	header.Name = filename
+	header.Name = filepath.ToSlash(entry.name)
+	header.Method = zipMethod(entry.name)
+	if entry.symlink != "" {
+		// Zip stores a symlink as an entry whose content is the target.
+		header.Method = zip.Store
+		data = strings.NewReader(filepath.ToSlash(entry.symlink))
+	}
 
-	if err := w.WriteHeader(header); err != nil {
+	writer, err := w.CreateHeader(header)
+	if err != nil {
 		return err
 	}
 
-	_, err = io.Copy(w, file)
+	_, err = io.Copy(writer, data)
 	return err
 }
 
-func allDirFiles(src string) ([]string, error) {
-	files := []string{}
+// compressedExtensions are formats that are already compressed, so deflating
+// them again costs time without saving space.
+var compressedExtensions = map[string]bool{
+	".zip": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".zst": true, ".7z": true,
+	".jpg": true, ".jpeg": true, ".jp2": true, ".png": true, ".gif": true, ".webp": true,
+	".mp3": true, ".ogg": true, ".flac": true, ".mp4": true, ".mkv": true, ".webm": true,
+}
 
-	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+// zipMethod picks Store for files that are already compressed and Deflate
+// for everything else.
+func zipMethod(name string) uint16 {
+	if compressedExtensions[strings.ToLower(filepath.Ext(name))] {
+		return zip.Store
+	}
+	return zip.Deflate
+}
+
+func writeTarArchive(w io.Writer, files []archiveFile, opts ArchiveOptions) error {
+	tarWriter := tar.NewWriter(w)
+	targets := dedupTargets{}
+
+	for _, file := range files {
+		key := targets.resolve(&file)
+		ok, err := addFileToTar(tarWriter, file, opts)
 		if err != nil {
 			return err
 		}
-		if info.Mode().IsRegular() {
-			files = append(files, path)
+		if ok {
+			targets.stored(key, file)
 		}
-		return nil
-	})
-
-	return files, err
+	}
+	return tarWriter.Close()
 }
 
-func (a DirectoryCompressionAlgorithm) Decode(src string) error {
-	dest := filepath.Dir(src)
-	switch a {
-	case ZIP:
-		if err := decodeZipArchive(src, dest); err != nil {
-			return err
+// addFileToTar writes entry to w. It reports false when SkipVanished left
+// the entry out.
+func addFileToTar(w *tar.Writer, entry archiveFile, opts ArchiveOptions) (bool, error) {
+	if entry.symlink != "" {
+		info, err := os.Lstat(entry.path)
+		if vanished(entry, err, opts) {
+			return false, nil
 		}
-	case TAR:
-		if err := decodeTarArchive(src, dest); err != nil {
-			return err
+		if err != nil {
+			return false, err
 		}
+		return true, addToTar(w, entry, info, nil, opts)
 	}
-	return nil
-}
 
-func decodeZipArchive(src, dest string) error {
-	r, err := zip.OpenReader(src)
+	file, err := os.Open(entry.path)
+	if vanished(entry, err, opts) {
+		return false, nil
+	}
 	if err != nil {
-		return err
+		return false, err
 	}
-	defer r.Close()
+	defer file.Close()
 
-	os.MkdirAll(dest, 0755)
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
 
-	for _, f := range r.File {
-		err := extractFromZip(f, dest)
+	data := tarData(entry, file, info, opts)
+	if opts.Checksums && entry.link == "" {
+		hashed, err := hashTarData(&entry, data, info)
 		if err != nil {
-			return err
+			return false, err
 		}
+		defer hashed.Close()
+		data = hashed
 	}
+	return true, addToTar(w, entry, info, data, opts)
+}
 
-	return nil
+// vanished reports whether SkipVanished lets entry be left out because it
+// was deleted after the directory walk listed it.
+func vanished(entry archiveFile, err error, opts ArchiveOptions) bool {
+	if !opts.SkipVanished || !os.IsNotExist(err) {
+		return false
+	}
+	logf("kognit: skipping %s: file vanished during archiving", entry.path)
+	return true
 }
 
-func extractFromZip(f *zip.File, dest string) error {
-	file, err := f.Open()
-	if err != nil {
-		return err
+// tarData makes a file that changes size while it is archived fit the size
+// already promised by its tar header, when SkipVanished allows it: extra
+// bytes are dropped and missing ones filled with zeros, as GNU tar does.
+func tarData(entry archiveFile, data io.Reader, info os.FileInfo, opts ArchiveOptions) io.Reader {
+	if !opts.SkipVanished {
+		return data
 	}
-	defer file.Close()
+	return &fixedSizeReader{r: data, remaining: info.Size(), path: entry.path}
+}
 
-	path := filepath.Join(dest, f.Name)
+type fixedSizeReader struct {
+	r         io.Reader
+	remaining int64
+	path      string
+	short     bool
+}
 
-	if f.FileInfo().IsDir() {
-		os.MkdirAll(path, 0755)
-	} else {
-		os.MkdirAll(filepath.Dir(path), 0755)
-		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
-		if err != nil {
-			return err
+func (f *fixedSizeReader) Read(p []byte) (int, error) {
+	if f.remaining <= 0 {
+		if n, _ := f.r.Read(make([]byte, 1)); n > 0 {
+			logf("kognit: %s grew during archiving, keeping its original size", f.path)
 		}
-		defer f.Close()
+		return 0, io.EOF
+	}
+	if int64(len(p)) > f.remaining {
+		p = p[:f.remaining]
+	}
 
-		_, err = io.Copy(f, file)
-		if err != nil {
-			return err
+	n := 0
+	if !f.short {
+		var err error
+		n, err = f.r.Read(p)
+		if err == io.EOF {
+			logf("kognit: %s shrank during archiving, padding it with zeros", f.path)
+			f.short = true
+		} else if err != nil {
+			return n, err
 		}
 	}
-
-	return nil
+	if f.short {
+		for i := n; i < len(p); i++ {
+			p[i] = 0
+		}
+		n = len(p)
+	}
+	f.remaining -= int64(n)
+	return n, nil
 }
 
-func decodeTarArchive(src, dest string) error {
-	stream, err := os.Open(src)
+func addToTar(w *tar.Writer, entry archiveFile, info os.FileInfo, data io.Reader, opts ArchiveOptions) error {
+	header, err := tar.FileInfoHeader(info, info.Name())
 	if err != nil {
 		return err
 	}
-	defer stream.Close()
 
-	gzipReader, err := gzip.NewReader(stream)
-	if err != nil {
+	header.Name = filepath.ToSlash(entry.name)
+	header.Format = opts.TarFormat
+	if header.Format == tar.FormatUSTAR {
+		// USTAR has whole-second modification times only, and no others.
+		header.ModTime = header.ModTime.Truncate(time.Second)
+		header.AccessTime = time.Time{}
+		header.ChangeTime = time.Time{}
+	}
+
+	for name, value := range entry.xattrs {
+		if header.PAXRecords == nil {
+			header.PAXRecords = map[string]string{}
+		}
+		header.PAXRecords[paxXattr+name] = value
+	}
+	if entry.sha256 != "" && entry.link == "" {
+		if header.PAXRecords == nil {
+			header.PAXRecords = map[string]string{}
+		}
+		header.PAXRecords[paxSHA256] = entry.sha256
+	}
+
+	if entry.symlink != "" {
+		header.Typeflag = tar.TypeSymlink
+		header.Linkname = filepath.ToSlash(entry.symlink)
+		header.Size = 0
+		return w.WriteHeader(header)
+	}
+	if entry.link != "" {
+		header.Typeflag = tar.TypeLink
+		header.Linkname = filepath.ToSlash(entry.link)
+		header.Size = 0
+		return w.WriteHeader(header)
+	}
+
+	if err := w.WriteHeader(header); err != nil {
 		return err
 	}
-	defer gzipReader.Close()
 
-	r := tar.NewReader(gzipReader)
+	_, err = io.Copy(w, data)
+	return err
+}
 
-	os.MkdirAll(dest, 0755)
+// allDirFiles lists the regular files under src, honouring the MaxDepth and
+// ExcludeLargerThan options. src's own files are one level down. Symlinks
+// are never followed, so linked directories cannot make the walk loop.
+func allDirFiles(src string, opts ArchiveOptions) ([]string, error) {
+	if err := checkSource(src); err != nil {
+		return nil, err
+	}
 
-	for true {
-		header, err := r.Next()
+	files := []string{}
+
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			if err == io.EOF {
-				break
-			}
 			return err
 		}
-
-		err = extractFromTar(r, header, dest)
-		if err != nil {
+		if keep, err := walkFilter(src, path, info, opts); !keep {
 			return err
 		}
+		if info.Mode().IsRegular() {
+			files = append(files, path)
+		}
+		return nil
+	})
+
+	return files, err
+}
+
+// walkFilter reports whether the MaxDepth and ExcludeLargerThan options
+// keep an entry stored as name. Directories past MaxDepth are skipped
+// entirely with filepath.SkipDir.
+func walkFilter(src, name string, info os.FileInfo, opts ArchiveOptions) (bool, error) {
+	if opts.MaxDepth > 0 && pathDepth(src, name) > opts.MaxDepth {
+		if info.IsDir() {
+			return false, filepath.SkipDir
+		}
+		return false, nil
 	}
+	if opts.ExcludeLargerThan > 0 && info.Size() > opts.ExcludeLargerThan {
+		return false, nil
+	}
+	return true, nil
+}
 
+// checkSource makes sure src is a directory or a regular file before it is
+// walked.
+func checkSource(src string) error {
+	info, err := os.Stat(src)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%s: %w", src, ErrSourceNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() && !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a file or directory: %w", src, ErrSourceNotFound)
+	}
 	return nil
 }
 
-func extractFromTar(r *tar.Reader, header *tar.Header, dest string) error {
-	switch header.Typeflag {
-	case tar.TypeDir:
-		if err := os.Mkdir(header.Name, 0755); err != nil {
-			return err
+// pathDepth counts the levels path is below root.
+func pathDepth(root, path string) int {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." {
+		return 0
+	}
+	return strings.Count(rel, string(filepath.Separator)) + 1
+}
+
+// dedupArchiveFiles hashes every file and points later files with the same
+// content at the first entry that stores it. Files that vanished before
+// they could be hashed are left out when SkipVanished allows it.
+func dedupArchiveFiles(files []archiveFile, opts ArchiveOptions) ([]archiveFile, error) {
+	seen := map[string]string{}
+	kept := files[:0]
+
+	for _, file := range files {
+		if file.symlink != "" {
+			kept = append(kept, file)
+			continue
+		}
+		sum, err := hashFile(file.path)
+		if vanished(file, err, opts) {
+			continue
 		}
-	case tar.TypeReg:
-		os.MkdirAll(filepath.Dir(header.Name), 0755)
-		f, err := os.OpenFile(header.Name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		defer f.Close()
 
-		if _, err := io.Copy(f, r); err != nil {
-			return err
+		if first, ok := seen[sum]; ok {
+			file.link = first
+		} else {
+			seen[sum] = file.name
 		}
+		kept = append(kept, file)
+	}
+	return kept, nil
+}
 
-	default:
-		return errors.New("Unknown header type")
+// dedupTargets records which entry actually stored the content of each set
+// of duplicates. The first copy can still vanish while it is written, and a
+// hard link to it would then dangle, so the next duplicate is stored in full
+// in its place.
+type dedupTargets map[string]string
+
+// resolve points entry's link at the stored copy, or clears it when none
+// has been stored. It returns the key to pass to stored.
+func (d dedupTargets) resolve(entry *archiveFile) string {
+	if entry.link == "" {
+		return entry.name
 	}
-	return nil
+	first := entry.link
+	entry.link = d[first]
+	return first
+}
+
+func (d dedupTargets) stored(key string, entry archiveFile) {
+	if _, ok := d[key]; !ok {
+		d[key] = entry.name
+	}
+}
+
+// Decode extracts src next to itself.
+func (a DirectoryCompressionAlgorithm) Decode(src string) error {
+	return DecodeInto(src, filepath.Dir(src), a)
+}
+
+func (a DirectoryCompressionAlgorithm) DecodeWithOptions(src string, opts ArchiveOptions) error {
+	return NewArchiver(a, WithOptions(opts)).Decode(src, filepath.Dir(src))
+}
+
+// DecodeInto extracts src into dest, creating dest if it does not exist.
+func DecodeInto(src, dest string, algo DirectoryCompressionAlgorithm) error {
+	return NewArchiver(algo).Decode(src, dest)
+}
+
+var (
+	ErrUnknownArchiveFormat = errors.New("unknown archive format")
+	ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
+	ErrIllegalPath          = errors.New("illegal file path")
+	ErrUnknownEntryType     = errors.New("unknown archive entry type")
+	ErrSpecialFile          = errors.New("device and FIFO entries are not extracted")
+	ErrSourceNotFound       = errors.New("source file or directory not found")
+)
+
+// DecodeAuto extracts src into dest, detecting the archive format from its
+// magic bytes rather than its extension.
+func DecodeAuto(src, dest string) error {
+	_, err := DecodeAutoWithResult(src, dest)
+	return err
+}
+
+// DecodeResult lists the paths a decode created, each joined under dest.
+// Entries skipped because of a conflict policy or a filter are left out.
+type DecodeResult struct {
+	Files []string
+	Dirs  []string
+}
+
+// DecodeAutoWithResult is DecodeAuto that also reports what was extracted.
+func DecodeAutoWithResult(src, dest string) (DecodeResult, error) {
+	a, err := detectArchiveFormat(src)
+	if err != nil {
+		return DecodeResult{}, err
+	}
+	x := &extraction{}
+	err = a.extract(src, dest, x)
+	return x.result, err
+}
+
+func detectArchiveFormat(src string) (DirectoryCompressionAlgorithm, error) {
+	f, _, err := openArchive(src)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	magic := make([]byte, 4)
+	n, err := io.ReadFull(f, magic)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return 0, err
+	}
+	magic = magic[:n]
+
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")), bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		return ZIP, nil
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return TAR, nil
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return ZSTD, nil
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return BZIP2, nil
+	}
+	return 0, fmt.Errorf("%s: %w", src, ErrUnknownArchiveFormat)
+}
+
+func (a DirectoryCompressionAlgorithm) decode(src, dest string, opts ArchiveOptions) error {
+	return a.extract(src, dest, &extraction{opts: opts})
+}
+
+func (a DirectoryCompressionAlgorithm) extract(src, dest string, x *extraction) error {
+	if x.opts.Passphrase != "" {
+		return a.decodeEncrypted(src, dest, x)
+	}
+
+	switch a {
+	case ZIP:
+		if err := decodeZipArchive(src, dest, x); err != nil {
+			return err
+		}
+	case TAR, ZSTD, BZIP2:
+		if err := a.decodeTarArchive(src, dest, x); err != nil {
+			return err
+		}
+	default:
+		return a.unsupported()
+	}
+	return nil
+}
+
+func (a DirectoryCompressionAlgorithm) decodeEncrypted(src, dest string, x *extraction) error {
+	f, _, err := openArchive(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r, err := newDecryptReader(f, x.opts.Passphrase)
+	if err != nil {
+		return err
+	}
+
+	switch a {
+	case ZIP:
+		// archive/zip needs random access, so the plaintext is staged in a
+		// temporary file first.
+		tmp, err := ioutil.TempFile("", "kognit-*.zip")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+
+		if _, err := io.Copy(tmp, r); err != nil {
+			return err
+		}
+		return decodeZipArchive(tmp.Name(), dest, x)
+	case TAR, ZSTD, BZIP2:
+		return a.decodeTarStream(r, dest, x)
+	}
+	return a.unsupported()
+}
+
+func decodeZipArchive(src, dest string, x *extraction) error {
+	archive, size, err := openArchive(src)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	r, err := zip.NewReader(archive, size)
+	if err != nil {
+		return err
+	}
+
+	x.checksums, err = readZipChecksums(r, archive, x.opts.ZipPassword)
+	if err != nil {
+		return err
+	}
+
+	os.MkdirAll(dest, 0755)
+
+	for _, f := range r.File {
+		if x.checksums != nil && f.Name == zipChecksumsName {
+			continue
+		}
+		if !x.wants(f.Name) {
+			continue
+		}
+		if err := x.count(f.Name); err != nil {
+			return err
+		}
+
+		err := extractFromZip(f, archive, dest, x)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func extractFromZip(f *zip.File, archive io.ReaderAt, dest string, x *extraction) error {
+	file, err := openZipEntry(f, archive, x.opts.ZipPassword)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	name, ok := x.entryName(f.Name)
+	if !ok {
+		return nil
+	}
+
+	path, err := entryPath(dest, name)
+	if err != nil {
+		return err
+	}
+	if err := checkParents(dest, path); err != nil {
+		return err
+	}
+
+	if f.FileInfo().IsDir() {
+		os.MkdirAll(path, 0755)
+		x.result.Dirs = append(x.result.Dirs, path)
+	} else if f.Mode()&os.ModeSymlink != 0 {
+		if write, err := checkConflict(path, x.opts.OnConflict); !write {
+			return err
+		}
+		target, err := ioutil.ReadAll(x.limit(file, path, zipSize(f.CompressedSize64)))
+		if err != nil {
+			return err
+		}
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := checkSymlink(dest, path, string(target)); err != nil {
+			if unsafeSymlink(err) {
+				return nil
+			}
+			return err
+		}
+		os.Remove(path)
+		if err := os.Symlink(filepath.FromSlash(string(target)), path); err != nil {
+			return err
+		}
+		x.result.Files = append(x.result.Files, path)
+	} else {
+		size, modified := zipSize(f.UncompressedSize64), f.Modified
+		if x.alreadyExtracted(path, size, modified) {
+			return nil
+		}
+		if write, err := checkConflict(path, x.opts.OnConflict); !write {
+			return err
+		}
+		if err := x.reserve(path, size); err != nil {
+			return err
+		}
+
+		data := verifyHash(x.limit(file, path, zipSize(f.CompressedSize64)), path, x.checksums[f.Name])
+
+		os.MkdirAll(filepath.Dir(path), 0755)
+		f, err := createEntryFile(path, 0755)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(f, data)
+		if err != nil {
+			return err
+		}
+		x.result.Files = append(x.result.Files, path)
+
+		// The modification time marks the file as complete for Resume.
+		if !modified.IsZero() {
+			return os.Chtimes(path, modified, modified)
+		}
+	}
+
+	return nil
+}
+
+// zipSize converts a zip64 size to int64. Sizes past math.MaxInt64 can only
+// come from a corrupt or hostile header; they are clamped rather than
+// wrapping around to negative values that would slip past the size limits.
+func zipSize(size uint64) int64 {
+	if size > math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return int64(size)
+}
+
+func (a DirectoryCompressionAlgorithm) decodeTarArchive(src, dest string, x *extraction) error {
+	stream, _, err := openArchive(src)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	if err := a.decodeTarStream(stream, dest, x); err != nil {
+		return err
+	}
+	return a.copyLinkTargets(src, x)
+}
+
+// copyLinkTargets gives the hard links whose target was filtered out of the
+// extraction that target's content, reading src a second time. The first
+// link gets a full copy and the others are linked to it.
+func (a DirectoryCompressionAlgorithm) copyLinkTargets(src string, x *extraction) error {
+	if len(x.links) == 0 {
+		return nil
+	}
+	err := a.readTarEntries(src, func(header *tar.Header, data io.Reader) error {
+		paths := x.links[header.Name]
+		if len(paths) == 0 || (header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA) {
+			return nil
+		}
+		delete(x.links, header.Name)
+
+		for i, path := range paths {
+			if i == 0 {
+				if err := x.reserve(path, header.Size); err != nil {
+					return err
+				}
+				if err := writeTarFile(x.limit(data, path, -1), path); err != nil {
+					return err
+				}
+			} else {
+				os.Remove(path)
+				if err := os.Link(paths[0], path); err != nil {
+					if err := copyFile(paths[0], path); err != nil {
+						return err
+					}
+				}
+			}
+			if err := applyTarAttributes(path, header); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for name := range x.links {
+		return fmt.Errorf("%s: link target %s: %w", src, name, ErrEntryNotFound)
+	}
+	return nil
+}
+
+// DecodeTarStream extracts a tar.gz read from stream into dest. The stream
+// is consumed sequentially, so it can come from a pipe or network.
+func DecodeTarStream(stream io.Reader, dest string) error {
+	return TAR.decodeTarStream(stream, dest, &extraction{})
+}
+
+func (a DirectoryCompressionAlgorithm) decodeTarStream(stream io.Reader, dest string, x *extraction) error {
+	x.stream = &countingReader{r: stream}
+	dr, err := a.decompressor(x.stream)
+	if err != nil {
+		return err
+	}
+	defer dr.Close()
+
+	r := tar.NewReader(dr)
+
+	os.MkdirAll(dest, 0755)
+
+	// Directory attributes are applied only once every entry has been
+	// written, otherwise a restrictive mode would block its own children
+	// and creating those children would bump the restored mtime.
+	dirs := []*tar.Header{}
+
+	for true {
+		header, err := r.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+
+		if !x.wants(header.Name) {
+			continue
+		}
+		if err := x.count(header.Name); err != nil {
+			return err
+		}
+
+		err = extractFromTar(r, header, dest, x)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := x.entryName(header.Name); ok && header.Typeflag == tar.TypeDir {
+			dirs = append(dirs, header)
+		}
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		name, _ := x.entryName(dirs[i].Name)
+		path := filepath.Join(dest, name)
+		// A later entry may have replaced the directory; never chmod
+		// through a symlink.
+		if info, err := os.Lstat(path); err != nil || !info.IsDir() {
+			continue
+		}
+		if err := applyTarAttributes(path, dirs[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func extractFromTar(r *tar.Reader, header *tar.Header, dest string, x *extraction) error {
+	name, ok := x.entryName(header.Name)
+	if !ok {
+		return nil
+	}
+
+	path, err := entryPath(dest, name)
+	if err != nil {
+		return err
+	}
+	if err := checkParents(dest, path); err != nil {
+		return err
+	}
+
+	if header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeRegA {
+		if x.alreadyExtracted(path, header.Size, header.ModTime) {
+			return nil
+		}
+	}
+	if header.Typeflag != tar.TypeDir {
+		if write, err := checkConflict(path, x.opts.OnConflict); !write {
+			return err
+		}
+	}
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return err
+		}
+		x.result.Dirs = append(x.result.Dirs, path)
+	case tar.TypeReg, tar.TypeRegA:
+		if err := x.reserve(path, header.Size); err != nil {
+			return err
+		}
+
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := writeTarFile(verifyHash(x.limit(r, path, -1), path, header.PAXRecords[paxSHA256]), path); err != nil {
+			return err
+		}
+		x.result.Files = append(x.result.Files, path)
+		if x.opts.Xattrs {
+			if err := writeXattrs(path, tarXattrs(header)); err != nil {
+				return err
+			}
+		}
+		return applyTarAttributes(path, header)
+	case tar.TypeLink:
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if x.match != nil && !x.match(header.Linkname) {
+			// The target was never written, so its content is copied
+			// here once the rest of the archive has been extracted.
+			if x.links == nil {
+				x.links = map[string][]string{}
+			}
+			x.links[header.Linkname] = append(x.links[header.Linkname], path)
+			x.result.Files = append(x.result.Files, path)
+			return nil
+		}
+		linkname, ok := x.entryName(header.Linkname)
+		if !ok {
+			return fmt.Errorf("%s: link target %s was stripped: %w", header.Name, header.Linkname, ErrIllegalPath)
+		}
+		target, err := entryPath(dest, linkname)
+		if err != nil {
+			return err
+		}
+		if err := checkLinkTarget(dest, target); err != nil {
+			return err
+		}
+		os.Remove(path)
+		if err := os.Link(target, path); err != nil {
+			if err := copyFile(target, path); err != nil {
+				return err
+			}
+		}
+		x.result.Files = append(x.result.Files, path)
+	case tar.TypeSymlink:
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := checkSymlink(dest, path, header.Linkname); err != nil {
+			if unsafeSymlink(err) {
+				return nil
+			}
+			return err
+		}
+		os.Remove(path)
+		if err := os.Symlink(filepath.FromSlash(header.Linkname), path); err != nil {
+			return err
+		}
+		if err := chownTar(path, header); err != nil {
+			return err
+		}
+		x.result.Files = append(x.result.Files, path)
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		if x.opts.RejectSpecialFiles {
+			return fmt.Errorf("%s (type %q): %w", header.Name, header.Typeflag, ErrSpecialFile)
+		}
+	case tar.TypeXGlobalHeader:
+	default:
+		return fmt.Errorf("%s (type %q): %w", header.Name, header.Typeflag, ErrUnknownEntryType)
+	}
+	return nil
+}
+
+// paxXattr prefixes extended attributes in PAX records, as GNU and BSD tar
+// store them.
+const paxXattr = "SCHILY.xattr."
+
+func tarXattrs(header *tar.Header) map[string]string {
+	xattrs := map[string]string{}
+	for key, value := range header.PAXRecords {
+		if strings.HasPrefix(key, paxXattr) {
+			xattrs[strings.TrimPrefix(key, paxXattr)] = value
+		}
+	}
+	return xattrs
+}
+
+// entryPath resolves an archive entry name under dest, rejecting names that
+// would land outside of it. Names are stored with forward slashes and only
+// converted to native separators here.
+func entryPath(dest, name string) (string, error) {
+	path := filepath.Join(dest, filepath.FromSlash(name))
+	rel, err := filepath.Rel(dest, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return "", fmt.Errorf("%s: %w", name, ErrIllegalPath)
+	}
+	return path, nil
+}
+
+// entryName applies StripComponents and Prefix to an archive entry name. It
+// reports false for entries that have nothing left once stripped.
+func (x *extraction) entryName(name string) (string, bool) {
+	if x.opts.StripComponents > 0 {
+		parts := strings.FieldsFunc(filepath.ToSlash(name), func(r rune) bool { return r == '/' })
+		for len(parts) > 0 && parts[0] == "." {
+			parts = parts[1:]
+		}
+		if len(parts) <= x.opts.StripComponents {
+			return "", false
+		}
+		name = strings.Join(parts[x.opts.StripComponents:], "/")
+	}
+	if x.opts.Prefix != "" {
+		name = filepath.Join(x.opts.Prefix, name)
+	}
+	return name, true
+}
+
+// checkSymlink rejects symlinks whose target is absolute or resolves
+// outside dest, so later entries cannot be written through them. The link's
+// directory must already exist: it is resolved first, and so is the target
+// when it exists, because ".." after an existing symlink climbs from where
+// the link points rather than from where it sits.
+func checkSymlink(dest, path, target string) error {
+	illegal := fmt.Errorf("%s -> %s: %w", path, target, ErrIllegalPath)
+	if filepath.IsAbs(target) {
+		return illegal
+	}
+
+	root, err := filepath.EvalSymlinks(dest)
+	if err != nil {
+		return err
+	}
+	parent, err := filepath.EvalSymlinks(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+
+	target = filepath.FromSlash(target)
+	if !isWithin(root, filepath.Join(parent, target)) {
+		return illegal
+	}
+	// filepath.Join would clean "a/.." away before a is followed.
+	if resolved, err := filepath.EvalSymlinks(parent + string(os.PathSeparator) + target); err == nil && !isWithin(root, resolved) {
+		return illegal
+	}
+	return nil
+}
+
+// unsafeSymlink reports whether err is checkSymlink rejecting the link,
+// which is then left out rather than failing the whole extraction: encode
+// stores symlinks as it finds them, and its own archives must still decode.
+func unsafeSymlink(err error) bool {
+	if !errors.Is(err, ErrIllegalPath) {
+		return false
+	}
+	logf("kognit: skipping symlink %v", err)
+	return true
+}
+
+// checkParents rejects path when a directory between dest and it is an
+// existing symlink resolving outside dest. Links that each passed
+// checkSymlink can still combine into an escape, such as a -> "." followed
+// by b -> "a/..", so every entry is checked against what is on disk before
+// it is created.
+func checkParents(dest, path string) error {
+	root, err := filepath.EvalSymlinks(dest)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(dest, filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+
+	current := dest
+	for _, part := range strings.Split(rel, string(os.PathSeparator)) {
+		if part == "." {
+			continue
+		}
+		current = filepath.Join(current, part)
+
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		if resolved, err := filepath.EvalSymlinks(current); err != nil || !isWithin(root, resolved) {
+			return fmt.Errorf("%s: %s leads outside the destination: %w", path, current, ErrIllegalPath)
+		}
+	}
+	return nil
+}
+
+// checkLinkTarget rejects a hard link target that is reached through, or
+// is itself, a symlink resolving outside dest.
+func checkLinkTarget(dest, target string) error {
+	if err := checkParents(dest, target); err != nil {
+		return err
+	}
+	info, err := os.Lstat(target)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return nil
+	}
+	root, err := filepath.EvalSymlinks(dest)
+	if err != nil {
+		return err
+	}
+	if resolved, err := filepath.EvalSymlinks(target); err != nil || !isWithin(root, resolved) {
+		return fmt.Errorf("link target %s leads outside the destination: %w", target, ErrIllegalPath)
+	}
+	return nil
+}
+
+// createEntryFile opens path for an extracted file's content. A symlink
+// already at path is replaced rather than written through.
+func createEntryFile(path string, perm os.FileMode) (*os.File, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+}
+
+// alreadyExtracted reports whether Resume allows skipping path because an
+// earlier run already wrote it: it has the entry's size and modification
+// time, and the time is only set once a file is complete.
+func (x *extraction) alreadyExtracted(path string, size int64, modTime time.Time) bool {
+	if !x.opts.Resume {
+		return false
+	}
+	info, err := os.Lstat(path)
+	return err == nil && info.Mode().IsRegular() && info.Size() == size && info.ModTime().Equal(modTime)
+}
+
+// checkConflict reports whether the extractor should write path, given
+// what is already on disk and the configured policy.
+func checkConflict(path string, policy ConflictPolicy) (bool, error) {
+	_, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	switch policy {
+	case Skip:
+		return false, nil
+	case Error:
+		return false, fmt.Errorf("%s: %w", path, ErrDestinationExists)
+	}
+	return true, nil
+}
+
+func writeTarFile(r io.Reader, path string) error {
+	f, err := createEntryFile(path, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(f, r)
+	return err
+}
+
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	return err
+}
+
+// applyTarAttributes restores ownership, mode and times in that order: chown
+// may clear setuid/setgid bits, and times have to be set last to stick.
+func applyTarAttributes(path string, header *tar.Header) error {
+	if err := chownTar(path, header); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(path, header.FileInfo().Mode()); err != nil {
+		return err
+	}
+
+	atime := header.AccessTime
+	if atime.IsZero() {
+		atime = header.ModTime
+	}
+	return os.Chtimes(path, atime, header.ModTime)
+}
+
+// chownTar gives path the entry's owner when running as root, the only
+// case where chown to another user can succeed. The recorded user and group
+// names win over the numeric ids when they exist here, as with GNU tar, so
+// backups restored on another system keep their owners by name.
+func chownTar(path string, header *tar.Header) error {
+	if os.Geteuid() != 0 {
+		return nil
+	}
+
+	uid, gid := header.Uid, header.Gid
+	if header.Uname != "" {
+		if u, err := user.Lookup(header.Uname); err == nil {
+			if id, err := strconv.Atoi(u.Uid); err == nil {
+				uid = id
+			}
+		}
+	}
+	if header.Gname != "" {
+		if g, err := user.LookupGroup(header.Gname); err == nil {
+			if id, err := strconv.Atoi(g.Gid); err == nil {
+				gid = id
+			}
+		}
+	}
+	return os.Lchown(path, uid, gid)
 }
@@ -0,0 +1,821 @@
+package kognit
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeArchive builds an archive of entries with BuildArchive and saves it
+// in dir.
+func writeArchive(t *testing.T, dir string, algo DirectoryCompressionAlgorithm, entries map[string][]byte) string {
+	t.Helper()
+	data, err := BuildArchive(entries, algo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "archive"+algo.extension())
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConflictPolicies(t *testing.T) {
+	tests := []struct {
+		policy  ConflictPolicy
+		want    string
+		wantErr error
+	}{
+		{Overwrite, "from archive", nil},
+		{Skip, "already there", nil},
+		{Error, "already there", ErrDestinationExists},
+	}
+
+	for _, algo := range []DirectoryCompressionAlgorithm{ZIP, TAR} {
+		for _, tt := range tests {
+			dir := t.TempDir()
+			archive := writeArchive(t, dir, algo, map[string][]byte{
+				"same.txt": []byte("from archive"),
+			})
+			dest := filepath.Join(dir, "dest")
+			writeTree(t, dest, map[string]string{"same.txt": "already there"})
+
+			err := NewArchiver(algo, WithConflictPolicy(tt.policy)).Decode(archive, dest)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("%s policy %d: Decode = %v, want %v", algo.extension(), tt.policy, err, tt.wantErr)
+			}
+			assertTree(t, dest, map[string]string{"same.txt": tt.want})
+		}
+	}
+}
+
+func TestSymlinkConflictPolicies(t *testing.T) {
+	tests := []struct {
+		policy   ConflictPolicy
+		replaced bool
+		wantErr  error
+	}{
+		{Overwrite, true, nil},
+		{Skip, false, nil},
+		{Error, false, ErrDestinationExists},
+	}
+
+	for _, algo := range []DirectoryCompressionAlgorithm{ZIP, TAR} {
+		for _, tt := range tests {
+			dir := t.TempDir()
+			archive := filepath.Join(dir, "links"+algo.extension())
+			if algo == ZIP {
+				writeZip(t, archive, []zipEntry{{name: "same.txt", mode: os.ModeSymlink | 0777, content: "other.txt"}})
+			} else {
+				writeTarGz(t, archive, []tarEntry{{header: tar.Header{Name: "same.txt", Typeflag: tar.TypeSymlink, Linkname: "other.txt"}}})
+			}
+			dest := filepath.Join(dir, "dest")
+			writeTree(t, dest, map[string]string{"same.txt": "already there"})
+
+			err := NewArchiver(algo, WithConflictPolicy(tt.policy)).Decode(archive, dest)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("%s policy %d: Decode = %v, want %v", algo.extension(), tt.policy, err, tt.wantErr)
+			}
+			target, err := os.Readlink(filepath.Join(dest, "same.txt"))
+			if replaced := err == nil && target == "other.txt"; replaced != tt.replaced {
+				t.Errorf("%s policy %d: same.txt replaced by the symlink = %v, want %v", algo.extension(), tt.policy, replaced, tt.replaced)
+			}
+		}
+	}
+}
+
+type tarEntry struct {
+	header  tar.Header
+	content string
+}
+
+// writeTarGz writes entries to a tar.gz at path, in order.
+func writeTarGz(t *testing.T, path string, entries []tarEntry) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	for _, e := range entries {
+		header := e.header
+		if header.Mode == 0 {
+			header.Mode = 0644
+		}
+		header.Size = int64(len(e.content))
+		if err := tw.WriteHeader(&header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractTarLinks(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "links.tar.gz")
+	writeTarGz(t, archive, []tarEntry{
+		{header: tar.Header{Name: "data/file.txt", Typeflag: tar.TypeReg}, content: "payload"},
+		{header: tar.Header{Name: "data/soft", Typeflag: tar.TypeSymlink, Linkname: "file.txt"}},
+		{header: tar.Header{Name: "data/hard", Typeflag: tar.TypeLink, Linkname: "data/file.txt"}},
+	})
+
+	dest := filepath.Join(dir, "dest")
+	if err := DecodeInto(archive, dest, TAR); err != nil {
+		t.Fatal(err)
+	}
+
+	if target, err := os.Readlink(filepath.Join(dest, "data", "soft")); err != nil || target != "file.txt" {
+		t.Errorf("data/soft -> %q, %v; want a symlink to file.txt", target, err)
+	}
+
+	file, err := os.Stat(filepath.Join(dest, "data", "file.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hard, err := os.Lstat(filepath.Join(dest, "data", "hard"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(file, hard) {
+		t.Error("data/hard is not a hard link to data/file.txt")
+	}
+}
+
+// Symlinks that would escape are skipped, so the entries after them land
+// in plain directories. A chain built backwards only escapes once its last
+// link exists, and hard links cannot be skipped, so those fail instead.
+func TestExtractTarLinkEscapes(t *testing.T) {
+	tests := []struct {
+		name    string
+		fails   bool
+		entries []tarEntry
+	}{
+		{"absolute symlink", false, []tarEntry{
+			{header: tar.Header{Name: "abs", Typeflag: tar.TypeSymlink, Linkname: "/tmp"}},
+			{header: tar.Header{Name: "abs/escaped.txt", Typeflag: tar.TypeReg}, content: "x"},
+		}},
+		{"relative symlink", false, []tarEntry{
+			{header: tar.Header{Name: "up", Typeflag: tar.TypeSymlink, Linkname: ".."}},
+			{header: tar.Header{Name: "up/escaped.txt", Typeflag: tar.TypeReg}, content: "x"},
+		}},
+		{"symlink chain", false, []tarEntry{
+			{header: tar.Header{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "."}},
+			{header: tar.Header{Name: "b", Typeflag: tar.TypeSymlink, Linkname: "a/.."}},
+			{header: tar.Header{Name: "b/escaped.txt", Typeflag: tar.TypeReg}, content: "x"},
+		}},
+		{"symlink chain built backwards", true, []tarEntry{
+			{header: tar.Header{Name: "b", Typeflag: tar.TypeSymlink, Linkname: "a/.."}},
+			{header: tar.Header{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "."}},
+			{header: tar.Header{Name: "b/escaped.txt", Typeflag: tar.TypeReg}, content: "x"},
+		}},
+		{"hard link through symlink chain", true, []tarEntry{
+			{header: tar.Header{Name: "b", Typeflag: tar.TypeSymlink, Linkname: "a/.."}},
+			{header: tar.Header{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "."}},
+			{header: tar.Header{Name: "escaped.txt", Typeflag: tar.TypeLink, Linkname: "b/secret.txt"}},
+		}},
+		{"dotdot hard link", true, []tarEntry{
+			{header: tar.Header{Name: "escaped.txt", Typeflag: tar.TypeLink, Linkname: "../secret.txt"}},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jail := t.TempDir()
+			writeTree(t, jail, map[string]string{"secret.txt": "outside"})
+			archive := filepath.Join(jail, "evil.tar.gz")
+			writeTarGz(t, archive, tt.entries)
+
+			dest := filepath.Join(jail, "dest")
+			err := DecodeInto(archive, dest, TAR)
+			if tt.fails && !errors.Is(err, ErrIllegalPath) {
+				t.Errorf("DecodeInto = %v, want ErrIllegalPath", err)
+			}
+			if !tt.fails && err != nil {
+				t.Errorf("DecodeInto = %v, want the unsafe symlink skipped", err)
+			}
+			if _, err := os.Lstat(filepath.Join(jail, "escaped.txt")); err == nil {
+				t.Error("an entry was written outside the destination")
+			}
+			if data, _ := ioutil.ReadFile(filepath.Join(dest, "escaped.txt")); string(data) == "outside" {
+				t.Error("a file outside the destination was linked into it")
+			}
+		})
+	}
+}
+
+type zipEntry struct {
+	name    string
+	mode    os.FileMode
+	content string
+}
+
+// writeZip writes entries to a zip at path, in order. Symlink entries
+// hold their target as content, as zip tools store them.
+func writeZip(t *testing.T, path string, entries []zipEntry) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for _, e := range entries {
+		header := &zip.FileHeader{Name: e.name, Method: zip.Store}
+		mode := e.mode
+		if mode == 0 {
+			mode = 0644
+		}
+		header.SetMode(mode)
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractZipSymlinkChain(t *testing.T) {
+	jail := t.TempDir()
+	archive := filepath.Join(jail, "evil.zip")
+	writeZip(t, archive, []zipEntry{
+		{name: "b", mode: os.ModeSymlink | 0777, content: "a/.."},
+		{name: "a", mode: os.ModeSymlink | 0777, content: "."},
+		{name: "b/escaped.txt", content: "x"},
+	})
+
+	dest := filepath.Join(jail, "dest")
+	if err := DecodeInto(archive, dest, ZIP); !errors.Is(err, ErrIllegalPath) {
+		t.Errorf("DecodeInto = %v, want ErrIllegalPath", err)
+	}
+	if _, err := os.Lstat(filepath.Join(jail, "escaped.txt")); err == nil {
+		t.Error("an entry was written outside the destination")
+	}
+}
+
+func TestTarChecksumCoversFittedData(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shrinks.txt")
+	if err := ioutil.WriteFile(path, []byte("0123456789"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The file shrank to four bytes after its header promised ten.
+	entry := archiveFile{path: path, name: "shrinks.txt"}
+	data := tarData(entry, strings.NewReader("0123"), info, ArchiveOptions{SkipVanished: true})
+	data, err = hashTarData(&entry, data, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	written, err := ioutil.ReadAll(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "0123\x00\x00\x00\x00\x00\x00"; string(written) != want {
+		t.Errorf("data = %q, want %q", written, want)
+	}
+	if entry.sha256 != sha256Hex(written) {
+		t.Error("checksum does not match the data written")
+	}
+}
+
+func TestDecodeSkipsUnsafeStoredSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	outside := filepath.Join(dir, "outside.txt")
+	writeTree(t, dir, map[string]string{"outside.txt": "outside", "src/file.txt": "inside"})
+	src := filepath.Join(dir, "src")
+	// Entries are named after the absolute src path, so "up" climbs past
+	// the extraction root from any depth.
+	up := strings.Repeat("../", strings.Count(src, string(os.PathSeparator))+2) + "outside.txt"
+	for name, target := range map[string]string{"abs": outside, "up": up, "rel": "file.txt"} {
+		if err := os.Symlink(target, filepath.Join(src, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, algo := range []DirectoryCompressionAlgorithm{ZIP, TAR} {
+		t.Run(algo.extension(), func(t *testing.T) {
+			archive := filepath.Join(t.TempDir(), "links"+algo.extension())
+			if err := NewArchiver(algo).Encode(src, archive); err != nil {
+				t.Fatal(err)
+			}
+			out := filepath.Join(t.TempDir(), "out")
+			if err := DecodeInto(archive, out, algo); err != nil {
+				t.Fatalf("DecodeInto = %v, want the unsafe symlinks skipped", err)
+			}
+
+			root := filepath.Join(out, src)
+			if target, err := os.Readlink(filepath.Join(root, "rel")); err != nil || target != "file.txt" {
+				t.Errorf("rel -> %q, %v; want a symlink to file.txt", target, err)
+			}
+			for _, name := range []string{"abs", "up"} {
+				if _, err := os.Lstat(filepath.Join(root, name)); !os.IsNotExist(err) {
+					t.Errorf("%s was extracted: %v", name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeManyKeepsSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	writeTree(t, src, map[string]string{"file.txt": "inside"})
+	if err := os.Symlink("file.txt", filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := filepath.Join(dir, "many.tar.gz")
+	if err := EncodeMany([]string{src}, archive, TAR); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out")
+	if err := DecodeInto(archive, out, TAR); err != nil {
+		t.Fatal(err)
+	}
+	if target, err := os.Readlink(filepath.Join(out, "src", "link")); err != nil || target != "file.txt" {
+		t.Errorf("src/link -> %q, %v; want a symlink to file.txt", target, err)
+	}
+}
+
+// readTarHeaders returns the headers of the tar.gz at path by name.
+func readTarHeaders(t *testing.T, path string) map[string]*tar.Header {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers := map[string]*tar.Header{}
+	r := tar.NewReader(gr)
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			return headers
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		headers[header.Name] = header
+	}
+}
+
+func TestFollowSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"src/file.txt":    "inside",
+		"outside.txt":     "outside",
+		"outdir/deep.txt": "deep",
+	})
+	src := filepath.Join(dir, "src")
+	links := map[string]string{
+		"inner":    "file.txt",
+		"out":      "../outside.txt",
+		"outdir":   "../outdir",
+		"loop":     ".",
+		"dangling": "missing.txt",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(src, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	name := func(rel string) string { return filepath.ToSlash(filepath.Join(src, rel)) }
+
+	t.Run("stored", func(t *testing.T) {
+		archive := filepath.Join(t.TempDir(), "links.tar.gz")
+		if err := NewArchiver(TAR).Encode(src, archive); err != nil {
+			t.Fatal(err)
+		}
+		headers := readTarHeaders(t, archive)
+		for link, target := range links {
+			h := headers[name(link)]
+			if h == nil || h.Typeflag != tar.TypeSymlink || h.Linkname != target {
+				t.Errorf("%s: header %+v, want a symlink to %s", link, h, target)
+			}
+		}
+		if h := headers[name("outdir/deep.txt")]; h != nil {
+			t.Error("the linked directory outside the tree was walked")
+		}
+	})
+
+	t.Run("followed", func(t *testing.T) {
+		archive := filepath.Join(t.TempDir(), "links.tar.gz")
+		if err := NewArchiver(TAR, WithFollowSymlinks()).Encode(src, archive); err != nil {
+			t.Fatal(err)
+		}
+		for rel, h := range readTarHeaders(t, archive) {
+			if h.Typeflag == tar.TypeSymlink {
+				t.Errorf("%s stored as a symlink", rel)
+			}
+		}
+
+		out := filepath.Join(t.TempDir(), "out")
+		if err := DecodeInto(archive, out, TAR); err != nil {
+			t.Fatal(err)
+		}
+		// The loop and the dangling link are skipped.
+		assertTree(t, filepath.Join(out, src), map[string]string{
+			"file.txt":        "inside",
+			"inner":           "inside",
+			"out":             "outside",
+			"outdir/deep.txt": "deep",
+		})
+	})
+}
+
+// BenchmarkEncodeManySmallFiles measures encoding a tree of tiny files, where
+// the buffered archive writer saves a write syscall per header and entry.
+func BenchmarkEncodeManySmallFiles(b *testing.B) {
+	dir := b.TempDir()
+	src := filepath.Join(dir, "src")
+	files := map[string]string{}
+	for i := 0; i < 1000; i++ {
+		files[fmt.Sprintf("d%02d/f%03d.txt", i%20, i)] = fmt.Sprintf("small file %d\n", i)
+	}
+	writeTree(b, src, files)
+
+	for _, algo := range []DirectoryCompressionAlgorithm{ZIP, TAR} {
+		archive := filepath.Join(dir, "bench"+algo.extension())
+		b.Run(algo.extension(), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := EncodeMany([]string{src}, archive, algo); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeAuto(t *testing.T) {
+	entries := map[string][]byte{"a.txt": []byte("alpha"), "sub/b.txt": []byte("bravo")}
+	want := map[string]string{"a.txt": "alpha", "sub/b.txt": "bravo"}
+
+	for _, algo := range []DirectoryCompressionAlgorithm{ZIP, TAR, ZSTD} {
+		dir := t.TempDir()
+		archive := writeArchive(t, dir, algo, entries)
+		// The format comes from the content, not the name.
+		renamed := filepath.Join(dir, "download.bin")
+		if err := os.Rename(archive, renamed); err != nil {
+			t.Fatal(err)
+		}
+		out := filepath.Join(dir, "out")
+		if err := DecodeAuto(renamed, out); err != nil {
+			t.Errorf("%s: %v", algo.extension(), err)
+			continue
+		}
+		assertTree(t, out, want)
+	}
+
+	dir := t.TempDir()
+	for name, content := range map[string]string{"garbage.zip": "this is not an archive", "empty.tar.gz": ""} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		err := DecodeAuto(path, filepath.Join(dir, "out"))
+		if !errors.Is(err, ErrUnknownArchiveFormat) || !strings.Contains(err.Error(), "unknown archive format") {
+			t.Errorf("DecodeAuto(%s) = %v, want ErrUnknownArchiveFormat", name, err)
+		}
+	}
+}
+
+func TestEncodeMissingSource(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "does-not-exist")
+	for _, algo := range []DirectoryCompressionAlgorithm{ZIP, TAR, ZSTD} {
+		dest := filepath.Join(dir, "out"+algo.extension())
+		if err := NewArchiver(algo).Encode(src, dest); err == nil {
+			t.Errorf("%s: Encode of a missing directory succeeded", algo.extension())
+		}
+		if _, err := os.Stat(dest); !os.IsNotExist(err) {
+			t.Errorf("%s: an archive was written anyway: %v", algo.extension(), err)
+		}
+	}
+}
+
+func TestEncodeManyKeepsCollidingNames(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "one", "docs")
+	second := filepath.Join(dir, "two", "docs")
+	writeTree(t, first, map[string]string{"README": "first readme"})
+	writeTree(t, second, map[string]string{"README": "second readme", "guide.txt": "guide"})
+	single := filepath.Join(dir, "notes.txt")
+	writeTree(t, dir, map[string]string{"notes.txt": "notes"})
+
+	for _, algo := range []DirectoryCompressionAlgorithm{ZIP, TAR} {
+		archive := filepath.Join(dir, "many"+algo.extension())
+		if err := EncodeMany([]string{first, second, single}, archive, algo); err != nil {
+			t.Fatal(err)
+		}
+		out := filepath.Join(dir, "out"+algo.extension())
+		if err := DecodeInto(archive, out, algo); err != nil {
+			t.Fatal(err)
+		}
+		assertTree(t, out, map[string]string{
+			"docs/README":      "first readme",
+			"docs_2/README":    "second readme",
+			"docs_2/guide.txt": "guide",
+			"notes.txt":        "notes",
+		})
+	}
+}
+
+func TestDedupStoresIdenticalFilesOnce(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	content := strings.Repeat("the same bytes ", 1000)
+	writeTree(t, src, map[string]string{"a.txt": content, "sub/b.txt": content, "sub/c.txt": content, "d.txt": "different"})
+
+	archive := filepath.Join(dir, "dedup.tar.gz")
+	if err := NewArchiver(TAR, WithDedup()).Encode(src, archive); err != nil {
+		t.Fatal(err)
+	}
+	var stored int64
+	links := 0
+	for _, h := range readTarHeaders(t, archive) {
+		switch h.Typeflag {
+		case tar.TypeReg:
+			stored += h.Size
+		case tar.TypeLink:
+			links++
+		}
+	}
+	if stored != int64(len(content)+len("different")) || links != 2 {
+		t.Errorf("stored %d bytes of content with %d links, want %d bytes and 2 links", stored, links, len(content)+len("different"))
+	}
+
+	out := filepath.Join(dir, "out")
+	if err := DecodeInto(archive, out, TAR); err != nil {
+		t.Fatal(err)
+	}
+	restored := filepath.Join(out, src)
+	assertTree(t, restored, map[string]string{"a.txt": content, "sub/b.txt": content, "sub/c.txt": content, "d.txt": "different"})
+	a, err := os.Stat(filepath.Join(restored, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"sub/b.txt", "sub/c.txt"} {
+		if info, err := os.Stat(filepath.Join(restored, name)); err != nil || !os.SameFile(a, info) {
+			t.Errorf("%s is not a hard link to a.txt: %v", name, err)
+		}
+	}
+}
+
+func TestDecodeTarStreamFromPipe(t *testing.T) {
+	data, err := BuildArchive(map[string][]byte{
+		"a.txt":     []byte("alpha"),
+		"sub/b.txt": []byte(strings.Repeat("bravo", 10000)),
+	}, TAR)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		// Small writes, so the reader sees the stream arrive piecemeal.
+		for len(data) > 0 {
+			n := 100
+			if n > len(data) {
+				n = len(data)
+			}
+			if _, err := pw.Write(data[:n]); err != nil {
+				return
+			}
+			data = data[n:]
+		}
+		pw.Close()
+	}()
+
+	out := t.TempDir()
+	if err := DecodeTarStream(pr, out); err != nil {
+		t.Fatal(err)
+	}
+	assertTree(t, out, map[string]string{"a.txt": "alpha", "sub/b.txt": strings.Repeat("bravo", 10000)})
+
+	// A stream that breaks off fails the decode.
+	pr, pw = io.Pipe()
+	go func() {
+		pw.Write([]byte{0x1f, 0x8b})
+		pw.CloseWithError(errors.New("connection reset"))
+	}()
+	if err := DecodeTarStream(pr, t.TempDir()); err == nil {
+		t.Error("DecodeTarStream of a broken stream succeeded")
+	}
+}
+
+// Stored names use forward slashes whatever the OS separator, which only
+// makes a difference on Windows but holds everywhere.
+func TestStoredNamesUseForwardSlashes(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	writeTree(t, src, map[string]string{"sub/deep/a.txt": "alpha", "b.txt": "bravo"})
+
+	for _, algo := range []DirectoryCompressionAlgorithm{ZIP, TAR} {
+		archive := filepath.Join(dir, "names"+algo.extension())
+		if err := NewArchiver(algo).Encode(src, archive); err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries := readArchiveBytes(t, data, algo)
+		if _, ok := entries[filepath.ToSlash(filepath.Join(src, "sub", "deep", "a.txt"))]; !ok {
+			t.Errorf("%s: sub/deep/a.txt not stored with slashes", algo.extension())
+		}
+		for name := range entries {
+			if strings.Contains(name, `\`) {
+				t.Errorf("%s: stored name %q has a backslash", algo.extension(), name)
+			}
+		}
+
+		out := filepath.Join(dir, "out"+algo.extension())
+		if err := DecodeInto(archive, out, algo); err != nil {
+			t.Fatal(err)
+		}
+		assertTree(t, filepath.Join(out, src), map[string]string{"sub/deep/a.txt": "alpha", "b.txt": "bravo"})
+	}
+}
+
+func TestMaxDepthExcludesDeeperFiles(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	writeTree(t, src, map[string]string{
+		"f0.txt":                  "0",
+		"l1/f1.txt":               "1",
+		"l1/l2/f2.txt":            "2",
+		"l1/l2/l3/f3.txt":         "3",
+		"l1/l2/l3/l4/f4.txt":      "4",
+		"l1/l2/l3/l4/l5/f5.txt":   "5",
+		"other/l2/l3/l4/l5/x.txt": "x",
+	})
+
+	for _, algo := range []DirectoryCompressionAlgorithm{ZIP, TAR} {
+		archive := filepath.Join(dir, "depth"+algo.extension())
+		if err := NewArchiver(algo, WithMaxDepth(2)).Encode(src, archive); err != nil {
+			t.Fatal(err)
+		}
+		out := filepath.Join(dir, "out"+algo.extension())
+		if err := DecodeInto(archive, out, algo); err != nil {
+			t.Fatal(err)
+		}
+		assertTree(t, filepath.Join(out, src), map[string]string{"f0.txt": "0", "l1/f1.txt": "1"})
+	}
+}
+
+func TestEncodeSourceChecks(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"single.txt": "single"})
+
+	for _, algo := range []DirectoryCompressionAlgorithm{ZIP, TAR} {
+		missing := filepath.Join(dir, "missing")
+		err := NewArchiver(algo).Encode(missing, filepath.Join(dir, "missing"+algo.extension()))
+		if !errors.Is(err, ErrSourceNotFound) || !strings.Contains(err.Error(), missing) {
+			t.Errorf("%s: Encode(missing) = %v, want ErrSourceNotFound naming the path", algo.extension(), err)
+		}
+
+		// A file where a directory is usual is archived on its own.
+		file := filepath.Join(dir, "single.txt")
+		archive := filepath.Join(dir, "single"+algo.extension())
+		if err := NewArchiver(algo).Encode(file, archive); err != nil {
+			t.Fatalf("%s: Encode(file) = %v", algo.extension(), err)
+		}
+		out := filepath.Join(dir, "out"+algo.extension())
+		if err := DecodeInto(archive, out, algo); err != nil {
+			t.Fatal(err)
+		}
+		assertTree(t, filepath.Join(out, dir), map[string]string{"single.txt": "single"})
+	}
+}
+
+func TestDecodeIntoOtherDirectory(t *testing.T) {
+	entries := map[string][]byte{"a.txt": []byte("alpha"), "sub/b.txt": []byte("bravo")}
+	want := map[string]string{"a.txt": "alpha", "sub/b.txt": "bravo"}
+
+	for _, algo := range []DirectoryCompressionAlgorithm{ZIP, TAR} {
+		archiveDir := t.TempDir()
+		archive := writeArchive(t, archiveDir, algo, entries)
+
+		// dest does not exist yet, and neither does its parent.
+		dest := filepath.Join(t.TempDir(), "restore", "here")
+		if err := DecodeInto(archive, dest, algo); err != nil {
+			t.Fatal(err)
+		}
+		assertTree(t, dest, want)
+		if got := readTree(t, archiveDir); len(got) != 1 {
+			t.Errorf("%s: DecodeInto wrote next to the archive: %v", algo.extension(), got)
+		}
+
+		// Decode keeps extracting next to the archive.
+		if err := algo.Decode(archive); err != nil {
+			t.Fatal(err)
+		}
+		got := readTree(t, archiveDir)
+		delete(got, filepath.Base(archive))
+		if len(got) != len(want) || got["a.txt"] != "alpha" || got["sub/b.txt"] != "bravo" {
+			t.Errorf("%s: Decode next to the archive gave %v", algo.extension(), got)
+		}
+	}
+}
+
+func TestExcludeLargerThan(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	writeTree(t, src, map[string]string{
+		"small.txt":      "small",
+		"at-limit.bin":   strings.Repeat("a", 2000),
+		"over-limit.bin": strings.Repeat("o", 2001),
+		"sub/huge.mp4":   strings.Repeat("h", 50000),
+		"sub/tiny.txt":   "tiny",
+	})
+	limit, err := ParseSize("2KB")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, algo := range []DirectoryCompressionAlgorithm{ZIP, TAR} {
+		archive := filepath.Join(dir, "small"+algo.extension())
+		if err := NewArchiver(algo, WithExcludeLargerThan(limit)).Encode(src, archive); err != nil {
+			t.Fatal(err)
+		}
+		out := filepath.Join(dir, "out"+algo.extension())
+		if err := DecodeInto(archive, out, algo); err != nil {
+			t.Fatal(err)
+		}
+		assertTree(t, filepath.Join(out, src), map[string]string{
+			"small.txt":    "small",
+			"at-limit.bin": strings.Repeat("a", 2000),
+			"sub/tiny.txt": "tiny",
+		})
+	}
+}
+
+func TestTarPAXLongPath(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	// Together with src, over 200 characters, and a last component too long
+	// for USTAR's 100-byte name field even when the rest goes in its prefix.
+	long := strings.Repeat("d", 60) + "/" + strings.Repeat("e", 30) + "/" + strings.Repeat("f", 110) + ".txt"
+	writeTree(t, src, map[string]string{long: "deep"})
+	if len(long) < 200 {
+		t.Fatalf("path is only %d characters", len(long))
+	}
+
+	archive := filepath.Join(dir, "pax.tar.gz")
+	if err := NewArchiver(TAR, WithTarFormat(tar.FormatPAX)).Encode(src, archive); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.ToSlash(filepath.Join(src, long))
+	h := readTarHeaders(t, archive)[name]
+	if h == nil {
+		t.Fatalf("%s not stored under its full name", name)
+	}
+	if h.Format != tar.FormatPAX {
+		t.Errorf("entry format %v, want PAX", h.Format)
+	}
+
+	out := filepath.Join(dir, "out")
+	if err := DecodeInto(archive, out, TAR); err != nil {
+		t.Fatal(err)
+	}
+	assertTree(t, filepath.Join(out, src), map[string]string{long: "deep"})
+
+	if err := NewArchiver(TAR, WithTarFormat(tar.FormatUSTAR)).Encode(src, filepath.Join(dir, "ustar.tar.gz")); err == nil {
+		t.Error("USTAR accepted a name it cannot hold")
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !windows
+// +build !windows
+
+package kognit
+
+import (
+	"errors"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestEncodeRejectsSpecialSource(t *testing.T) {
+	dir := t.TempDir()
+	fifo := filepath.Join(dir, "fifo")
+	if err := syscall.Mkfifo(fifo, 0644); err != nil {
+		t.Skip(err)
+	}
+	err := NewArchiver(TAR).Encode(fifo, filepath.Join(dir, "fifo.tar.gz"))
+	if !errors.Is(err, ErrSourceNotFound) {
+		t.Errorf("Encode(fifo) = %v, want ErrSourceNotFound", err)
+	}
+}
@@ -0,0 +1,202 @@
+package kognit
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Encrypted archives start with a small header followed by the archive
+// split into AES-GCM sealed chunks. Each chunk's nonce is the base nonce
+// with the chunk index mixed in, and the final chunk is marked through its
+// additional data so truncated files are rejected.
+const (
+	encryptMagic     = "KGNE"
+	encryptVersion   = 1
+	encryptSaltSize  = 16
+	encryptChunkSize = 64 * 1024
+)
+
+var ErrDecryptFailed = errors.New("decryption failed: wrong passphrase or corrupted archive")
+
+func deriveKey(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(base []byte, index uint64) []byte {
+	nonce := make([]byte, len(base))
+	copy(nonce, base)
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], index)
+	for i := range counter {
+		nonce[len(nonce)-8+i] ^= counter[i]
+	}
+	return nonce
+}
+
+func chunkAdditionalData(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+type encryptWriter struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	nonce []byte
+	index uint64
+	buf   []byte
+}
+
+func newEncryptWriter(w io.Writer, passphrase string) (*encryptWriter, error) {
+	salt := make([]byte, encryptSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	aead, err := deriveKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	header := append([]byte(encryptMagic), encryptVersion)
+	header = append(header, salt...)
+	header = append(header, nonce...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &encryptWriter{w: w, aead: aead, nonce: nonce, buf: make([]byte, 0, encryptChunkSize)}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// A full buffer is only sealed once more data arrives, so the last
+		// chunk is always the one sealed by Close.
+		if len(e.buf) == encryptChunkSize {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+
+		n := copy(e.buf[len(e.buf):encryptChunkSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (e *encryptWriter) Close() error {
+	return e.seal(true)
+}
+
+func (e *encryptWriter) seal(final bool) error {
+	sealed := e.aead.Seal(nil, chunkNonce(e.nonce, e.index), e.buf, chunkAdditionalData(final))
+	e.index++
+	e.buf = e.buf[:0]
+
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+type decryptReader struct {
+	r     io.Reader
+	aead  cipher.AEAD
+	nonce []byte
+	index uint64
+	chunk []byte
+	plain []byte
+	done  bool
+}
+
+func newDecryptReader(r io.Reader, passphrase string) (*decryptReader, error) {
+	header := make([]byte, len(encryptMagic)+1+encryptSaltSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, ErrDecryptFailed
+	}
+	if !bytes.HasPrefix(header, []byte(encryptMagic)) || header[len(encryptMagic)] != encryptVersion {
+		return nil, ErrDecryptFailed
+	}
+
+	aead, err := deriveKey(passphrase, header[len(encryptMagic)+1:])
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(r, nonce); err != nil {
+		return nil, ErrDecryptFailed
+	}
+
+	return &decryptReader{r: r, aead: aead, nonce: nonce}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// open reads and authenticates the next chunk. One byte past a full chunk is
+// read ahead to tell whether it is the last one.
+func (d *decryptReader) open() error {
+	size := encryptChunkSize + d.aead.Overhead()
+	buf := make([]byte, size+1)
+	copy(buf, d.chunk)
+
+	n, err := io.ReadFull(d.r, buf[len(d.chunk):])
+	n += len(d.chunk)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+
+	final := n <= size
+	if !final {
+		d.chunk = buf[size:n]
+		n = size
+	} else {
+		d.chunk = nil
+	}
+
+	plain, err := d.aead.Open(nil, chunkNonce(d.nonce, d.index), buf[:n], chunkAdditionalData(final))
+	if err != nil {
+		return ErrDecryptFailed
+	}
+	d.index++
+	d.plain = plain
+	d.done = final
+	return nil
+}
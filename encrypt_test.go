@@ -0,0 +1,53 @@
+package kognit
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryptedArchiveRoundTrip(t *testing.T) {
+	for _, algo := range []DirectoryCompressionAlgorithm{ZIP, TAR, ZSTD} {
+		t.Run(algo.extension(), func(t *testing.T) {
+			dir := t.TempDir()
+			src := filepath.Join(dir, "src")
+			files := map[string]string{"a.txt": "alpha", "sub/b.txt": "bravo"}
+			writeTree(t, src, files)
+
+			archive := filepath.Join(dir, "backup"+algo.extension())
+			if err := NewArchiver(algo, WithPassphrase("correct horse")).Encode(src, archive); err != nil {
+				t.Fatal(err)
+			}
+
+			out := filepath.Join(dir, "out")
+			if err := NewArchiver(algo, WithPassphrase("correct horse")).Decode(archive, out); err != nil {
+				t.Fatal(err)
+			}
+			assertTree(t, filepath.Join(out, src), files)
+		})
+	}
+}
+
+func TestEncryptedArchiveWrongPassphrase(t *testing.T) {
+	for _, algo := range []DirectoryCompressionAlgorithm{ZIP, TAR} {
+		t.Run(algo.extension(), func(t *testing.T) {
+			dir := t.TempDir()
+			src := filepath.Join(dir, "src")
+			writeTree(t, src, map[string]string{"secret.txt": "hunter2"})
+
+			archive := filepath.Join(dir, "backup"+algo.extension())
+			if err := NewArchiver(algo, WithPassphrase("correct horse")).Encode(src, archive); err != nil {
+				t.Fatal(err)
+			}
+
+			out := filepath.Join(dir, "out")
+			err := NewArchiver(algo, WithPassphrase("battery staple")).Decode(archive, out)
+			if !errors.Is(err, ErrDecryptFailed) {
+				t.Fatalf("Decode with wrong passphrase = %v, want ErrDecryptFailed", err)
+			}
+			if files := readTree(t, out); len(files) != 0 {
+				t.Errorf("wrong passphrase extracted %v", files)
+			}
+		})
+	}
+}
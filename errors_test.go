@@ -0,0 +1,51 @@
+package kognit
+
+import (
+	"archive/tar"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestErrorSentinels(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	writeTree(t, src, map[string]string{"a.txt": "alpha"})
+
+	escapeTar := filepath.Join(dir, "escape.tar.gz")
+	writeTarGz(t, escapeTar, []tarEntry{{header: tar.Header{Name: "../escape.txt", Typeflag: tar.TypeReg}, content: "out"}})
+	escapeZip := filepath.Join(dir, "escape.zip")
+	writeZip(t, escapeZip, []zipEntry{{name: "../escape.txt", mode: 0644, content: "out"}})
+	oddTar := filepath.Join(dir, "odd.tar.gz")
+	writeTarGz(t, oddTar, []tarEntry{{header: tar.Header{Name: "odd", Typeflag: 'Z'}}})
+
+	for _, tt := range []struct {
+		name string
+		run  func() error
+		want error
+		// mention is what the message must name.
+		mention string
+	}{
+		{"tar path escape", func() error { return DecodeInto(escapeTar, filepath.Join(dir, "out1"), TAR) }, ErrIllegalPath, "../escape.txt"},
+		{"zip path escape", func() error { return DecodeInto(escapeZip, filepath.Join(dir, "out2"), ZIP) }, ErrIllegalPath, "../escape.txt"},
+		{"unknown tar entry", func() error { return DecodeInto(oddTar, filepath.Join(dir, "out3"), TAR) }, ErrUnknownEntryType, "odd"},
+		{"unknown algorithm value", func() error {
+			return NewArchiver(DirectoryCompressionAlgorithm(99)).Encode(src, filepath.Join(dir, "out.bin"))
+		}, ErrUnsupportedAlgorithm, "99"},
+		{"unknown algorithm name", func() error { _, err := LookupAlgorithm("rar"); return err }, ErrUnsupportedAlgorithm, "rar"},
+		{"unknown archive format", func() error { return DecodeAuto(filepath.Join(src, "a.txt"), filepath.Join(dir, "out4")) }, ErrUnknownArchiveFormat, "a.txt"},
+		{"missing source", func() error {
+			return NewArchiver(TAR).Encode(filepath.Join(dir, "nope"), filepath.Join(dir, "out.tar.gz"))
+		}, ErrSourceNotFound, "nope"},
+	} {
+		err := tt.run()
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.mention) {
+			t.Errorf("%s: %q does not mention %q", tt.name, err, tt.mention)
+		}
+	}
+}
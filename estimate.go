@@ -0,0 +1,38 @@
+package kognit
+
+import (
+	"io"
+	"io/ioutil"
+	"path/filepath"
+)
+
+type EstimateResult struct {
+	CompressedBytes int64
+	Files           int
+}
+
+// EncodeEstimate runs the encoder for src without writing anything to disk
+// and reports the size the archive would have.
+func EncodeEstimate(src string, algo DirectoryCompressionAlgorithm) (EstimateResult, error) {
+	entries, err := dirArchiveFiles(src, ArchiveOptions{})
+	if err != nil {
+		return EstimateResult{}, err
+	}
+
+	w := &countingWriter{w: ioutil.Discard}
+	if err := algo.write(w, filepath.Base(src)+algo.extension(), entries, ArchiveOptions{}); err != nil {
+		return EstimateResult{}, err
+	}
+	return EstimateResult{CompressedBytes: w.n, Files: len(entries)}, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
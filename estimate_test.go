@@ -0,0 +1,36 @@
+package kognit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncodeEstimateMatchesArchive(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src")
+	writeTree(t, src, map[string]string{
+		"a.txt":       strings.Repeat("alpha ", 1000),
+		"sub/b.txt":   "bravo",
+		"sub/d/e.txt": strings.Repeat("echo", 5000),
+	})
+
+	for _, algo := range []DirectoryCompressionAlgorithm{ZIP, TAR, ZSTD} {
+		estimate, err := EncodeEstimate(src, algo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Encode names the archive after src, as the estimate does, so the
+		// gzip header is the same length.
+		if err := algo.Encode(src); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(src + algo.extension())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if estimate.CompressedBytes != info.Size() || estimate.Files != 3 {
+			t.Errorf("%s: estimate %+v, archive is %d bytes of 3 files", algo.extension(), estimate, info.Size())
+		}
+	}
+}
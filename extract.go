@@ -0,0 +1,46 @@
+package kognit
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var ErrEntryNotFound = errors.New("archive entry not found")
+
+// ExtractEntry writes only the entry named entryName from src into dest,
+// keeping its archived path. Zip lookups use the central directory; tar
+// archives are scanned sequentially.
+func ExtractEntry(src, entryName, dest string, algo DirectoryCompressionAlgorithm) error {
+	entryName = filepath.ToSlash(entryName)
+	x := &extraction{match: func(name string) bool { return name == entryName }}
+	if err := algo.extract(src, dest, x); err != nil {
+		return err
+	}
+	if x.matched == 0 {
+		return fmt.Errorf("%s: %w", entryName, ErrEntryNotFound)
+	}
+	return nil
+}
+
+// ExtractGlob writes every entry of src whose name matches pattern into
+// dest. Patterns without a separator are also matched against the entry's
+// base name, so "*.txt" selects text files at any depth.
+func ExtractGlob(src, pattern, dest string, algo DirectoryCompressionAlgorithm) error {
+	pattern = filepath.ToSlash(pattern)
+	if _, err := path.Match(pattern, ""); err != nil {
+		return err
+	}
+
+	matchBase := !strings.Contains(pattern, "/")
+	x := &extraction{match: func(name string) bool {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+		ok, _ := path.Match(pattern, path.Base(name))
+		return matchBase && ok
+	}}
+	return algo.extract(src, dest, x)
+}
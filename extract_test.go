@@ -0,0 +1,201 @@
+package kognit
+
+import (
+	"archive/tar"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestExtractEntry(t *testing.T) {
+	for _, algo := range []DirectoryCompressionAlgorithm{ZIP, TAR} {
+		t.Run(algo.extension(), func(t *testing.T) {
+			dir := t.TempDir()
+			archive := writeArchive(t, dir, algo, map[string][]byte{
+				"a.txt":     []byte("a"),
+				"sub/b.txt": []byte("b"),
+				"sub/c.txt": []byte("c"),
+			})
+
+			dest := filepath.Join(dir, "out")
+			if err := ExtractEntry(archive, "sub/b.txt", dest, algo); err != nil {
+				t.Fatal(err)
+			}
+			assertTree(t, dest, map[string]string{"sub/b.txt": "b"})
+
+			if err := ExtractEntry(archive, "missing.txt", dest, algo); !errors.Is(err, ErrEntryNotFound) {
+				t.Errorf("ExtractEntry(missing.txt) = %v, want ErrEntryNotFound", err)
+			}
+		})
+	}
+}
+
+func TestExtractGlob(t *testing.T) {
+	for _, algo := range []DirectoryCompressionAlgorithm{ZIP, TAR} {
+		t.Run(algo.extension(), func(t *testing.T) {
+			dir := t.TempDir()
+			archive := writeArchive(t, dir, algo, map[string][]byte{
+				"notes.txt":     []byte("notes"),
+				"image.png":     []byte("png"),
+				"sub/todo.txt":  []byte("todo"),
+				"sub/data.json": []byte("{}"),
+			})
+
+			dest := filepath.Join(dir, "out")
+			if err := ExtractGlob(archive, "*.txt", dest, algo); err != nil {
+				t.Fatal(err)
+			}
+			assertTree(t, dest, map[string]string{"notes.txt": "notes", "sub/todo.txt": "todo"})
+		})
+	}
+}
+
+// With Dedup, the second copy is a hard link to the first, which the
+// selection leaves out.
+func TestExtractDedupLinks(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	writeTree(t, src, map[string]string{"a.txt": "same", "b.txt": "same", "c.txt": "same", "d.txt": "other"})
+	archive := filepath.Join(dir, "dedup.tar.gz")
+	if err := NewArchiver(TAR, WithDedup()).Encode(src, archive); err != nil {
+		t.Fatal(err)
+	}
+	name := func(base string) string { return filepath.ToSlash(filepath.Join(src, base)) }
+
+	out := filepath.Join(dir, "entry")
+	if err := ExtractEntry(archive, name("b.txt"), out, TAR); err != nil {
+		t.Fatal(err)
+	}
+	assertTree(t, filepath.Join(out, src), map[string]string{"b.txt": "same"})
+
+	out = filepath.Join(dir, "glob")
+	if err := ExtractGlob(archive, "[bc].txt", out, TAR); err != nil {
+		t.Fatal(err)
+	}
+	assertTree(t, filepath.Join(out, src), map[string]string{"b.txt": "same", "c.txt": "same"})
+}
+
+func TestStripComponentsAndPrefix(t *testing.T) {
+	entries := map[string][]byte{
+		"top/a.txt":     []byte("alpha"),
+		"top/sub/b.txt": []byte("bravo"),
+		"root.txt":      []byte("root"),
+	}
+	for _, tt := range []struct {
+		name string
+		opts []Option
+		want map[string]string
+	}{
+		{"strip one", []Option{WithStripComponents(1)}, map[string]string{"a.txt": "alpha", "sub/b.txt": "bravo"}},
+		{"strip two", []Option{WithStripComponents(2)}, map[string]string{"b.txt": "bravo"}},
+		{"prefix", []Option{WithPrefix("nested/dir")}, map[string]string{
+			"nested/dir/top/a.txt": "alpha", "nested/dir/top/sub/b.txt": "bravo", "nested/dir/root.txt": "root",
+		}},
+		{"strip and prefix", []Option{WithStripComponents(1), WithPrefix("nested")}, map[string]string{
+			"nested/a.txt": "alpha", "nested/sub/b.txt": "bravo",
+		}},
+	} {
+		for _, algo := range []DirectoryCompressionAlgorithm{ZIP, TAR} {
+			dir := t.TempDir()
+			archive := writeArchive(t, dir, algo, entries)
+			out := filepath.Join(dir, "out")
+			if err := NewArchiver(algo, tt.opts...).Decode(archive, out); err != nil {
+				t.Fatalf("%s, %s: %v", algo.extension(), tt.name, err)
+			}
+			assertTree(t, out, tt.want)
+		}
+	}
+}
+
+func TestDecodeResultPaths(t *testing.T) {
+	dir := t.TempDir()
+	tarArchive := filepath.Join(dir, "result.tar.gz")
+	writeTarGz(t, tarArchive, []tarEntry{
+		{header: tar.Header{Name: "d/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{header: tar.Header{Name: "d/a.txt", Typeflag: tar.TypeReg}, content: "alpha"},
+		{header: tar.Header{Name: "d/e/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{header: tar.Header{Name: "b.txt", Typeflag: tar.TypeReg}, content: "bravo"},
+	})
+	zipArchive := filepath.Join(dir, "result.zip")
+	writeZip(t, zipArchive, []zipEntry{
+		{name: "d/", mode: os.ModeDir | 0755},
+		{name: "d/a.txt", content: "alpha"},
+		{name: "d/e/", mode: os.ModeDir | 0755},
+		{name: "b.txt", content: "bravo"},
+	})
+
+	for archive, algo := range map[string]DirectoryCompressionAlgorithm{tarArchive: TAR, zipArchive: ZIP} {
+		for name, decode := range map[string]func(src, dest string) (DecodeResult, error){
+			"auto":     DecodeAutoWithResult,
+			"archiver": NewArchiver(algo).DecodeWithResult,
+		} {
+			dest := filepath.Join(t.TempDir(), "out")
+			result, err := decode(archive, dest)
+			if err != nil {
+				t.Fatalf("%s, %s: %v", filepath.Base(archive), name, err)
+			}
+			sort.Strings(result.Files)
+			sort.Strings(result.Dirs)
+			want := DecodeResult{
+				Files: []string{filepath.Join(dest, "b.txt"), filepath.Join(dest, "d", "a.txt")},
+				Dirs:  []string{filepath.Join(dest, "d"), filepath.Join(dest, "d", "e")},
+			}
+			if !reflect.DeepEqual(result, want) {
+				t.Errorf("%s, %s: result %+v, want %+v", filepath.Base(archive), name, result, want)
+			}
+			for _, path := range result.Files {
+				if _, err := os.Stat(path); err != nil {
+					t.Errorf("%s, %s: listed file %v", filepath.Base(archive), name, err)
+				}
+			}
+		}
+	}
+}
+
+func TestResumeWritesOnlyMissingFiles(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	files := map[string]string{"done.txt": "done", "partial.txt": "partial", "missing.txt": "missing", "sub/done.txt": "sub"}
+	writeTree(t, src, files)
+	mtime := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	for name := range files {
+		if err := os.Chtimes(filepath.Join(src, filepath.FromSlash(name)), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, algo := range []DirectoryCompressionAlgorithm{ZIP, TAR} {
+		archive := filepath.Join(dir, "resume"+algo.extension())
+		if err := NewArchiver(algo).Encode(src, archive); err != nil {
+			t.Fatal(err)
+		}
+
+		// What an interrupted extraction left: two finished files, whose
+		// content is marked to show they are not rewritten, one cut short
+		// and one never reached.
+		out := filepath.Join(dir, "out"+algo.extension())
+		restored := filepath.Join(out, src)
+		writeTree(t, restored, map[string]string{"done.txt": "DONE", "sub/done.txt": "SUB", "partial.txt": "par"})
+		for _, name := range []string{"done.txt", "sub/done.txt", "partial.txt"} {
+			if err := os.Chtimes(filepath.Join(restored, filepath.FromSlash(name)), mtime, mtime); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		result, err := NewArchiver(algo, WithResume()).DecodeWithResult(archive, out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertTree(t, restored, map[string]string{"done.txt": "DONE", "sub/done.txt": "SUB", "partial.txt": "partial", "missing.txt": "missing"})
+
+		sort.Strings(result.Files)
+		want := []string{filepath.Join(restored, "missing.txt"), filepath.Join(restored, "partial.txt")}
+		if !reflect.DeepEqual(result.Files, want) {
+			t.Errorf("%s: resumed decode wrote %q, want %q", algo.extension(), result.Files, want)
+		}
+	}
+}
@@ -1,3 +1,9 @@
 module github.com/csothen/kognit
 
 go 1.15
+
+require (
+	github.com/chai2010/webp v1.1.0
+	github.com/klauspost/compress v1.11.13
+	golang.org/x/crypto v0.0.0-20210322153248-0c34fe9e7dc2
+)
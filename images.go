@@ -0,0 +1,371 @@
+package kognit
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	JPEG ImageCompressionAlgorithm = iota
+	JPEG2000
+	PNG
+	GIF
+	WEBP
+)
+
+// There is no pure Go JPEG 2000 codec, so JPEG2000 reports this rather than
+// pretending to work.
+var ErrUnsupportedFormat = errors.New("image format is not supported")
+
+// ImageOptions tunes the image encoders. Zero values select the defaults.
+type ImageOptions struct {
+	// Quality is the JPEG and WebP quality, from 1 to 100.
+	Quality int
+	// CompressionLevel is the zlib effort used for PNG output.
+	CompressionLevel png.CompressionLevel
+	// MaxWidth and MaxHeight bound the output size. The image is scaled
+	// down to fit, keeping its aspect ratio, using Resample.
+	MaxWidth  int
+	MaxHeight int
+	Resample  ResampleFilter
+}
+
+// ErrSameImage is returned when an image would be written over its own
+// source.
+var ErrSameImage = errors.New("output would overwrite the source image")
+
+// Encode re-encodes dataPath next to it, named after it with a's extension.
+func (a ImageCompressionAlgorithm) Encode(dataPath string) error {
+	return a.EncodeWithOptions(dataPath, ImageOptions{})
+}
+
+func (a ImageCompressionAlgorithm) EncodeWithOptions(dataPath string, opts ImageOptions) error {
+	return a.EncodeTo(dataPath, withExt(dataPath, a.extension()), opts)
+}
+
+// EncodeTo re-encodes the image at src into dest. It refuses to write over
+// src, which re-encoding an image to its own format would otherwise do.
+func (a ImageCompressionAlgorithm) EncodeTo(src, dest string, opts ImageOptions) error {
+	if a == JPEG2000 {
+		return fmt.Errorf("JPEG2000 encoding: %w", ErrUnsupportedFormat)
+	}
+	if err := checkImageDest(src, dest); err != nil {
+		return err
+	}
+
+	switch a {
+	case JPEG:
+		img, err := loadImage(src, opts)
+		if err != nil {
+			return err
+		}
+		return encodeJPEG(img, dest, opts)
+	case PNG:
+		img, err := loadImage(src, opts)
+		if err != nil {
+			return err
+		}
+		return encodePNG(img, dest, opts)
+	case GIF:
+		return encodeGIF(src, dest, opts)
+	case WEBP:
+		img, err := loadImage(src, opts)
+		if err != nil {
+			return err
+		}
+		return encodeWebP(img, dest, opts)
+	}
+	return nil
+}
+
+// checkImageDest rejects a dest that is src itself, under the same name or
+// another one.
+func checkImageDest(src, dest string) error {
+	same := filepath.Clean(src) == filepath.Clean(dest)
+	if !same {
+		srcInfo, srcErr := os.Stat(src)
+		destInfo, destErr := os.Stat(dest)
+		same = srcErr == nil && destErr == nil && os.SameFile(srcInfo, destInfo)
+	}
+	if same {
+		return fmt.Errorf("%s: %w", dest, ErrSameImage)
+	}
+	return nil
+}
+
+// ImageReport describes the result of an encode. Quality is only set for
+// lossy formats.
+type ImageReport struct {
+	OriginalBytes   int64
+	CompressedBytes int64
+	Width           int
+	Height          int
+	Quality         int
+}
+
+func (a ImageCompressionAlgorithm) EncodeReport(dataPath string) (ImageReport, error) {
+	return a.EncodeReportWithOptions(dataPath, ImageOptions{})
+}
+
+func (a ImageCompressionAlgorithm) EncodeReportWithOptions(dataPath string, opts ImageOptions) (ImageReport, error) {
+	report := ImageReport{}
+
+	info, err := os.Stat(dataPath)
+	if err != nil {
+		return report, err
+	}
+	report.OriginalBytes = info.Size()
+
+	if err := a.EncodeWithOptions(dataPath, opts); err != nil {
+		return report, err
+	}
+
+	dest := withExt(dataPath, a.extension())
+	f, err := os.Open(dest)
+	if err != nil {
+		return report, err
+	}
+	defer f.Close()
+
+	info, err = f.Stat()
+	if err != nil {
+		return report, err
+	}
+	report.CompressedBytes = info.Size()
+
+	config, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return report, err
+	}
+	report.Width, report.Height = config.Width, config.Height
+
+	if a == JPEG || a == WEBP {
+		report.Quality, _ = lossyQuality(opts)
+	}
+	return report, nil
+}
+
+// Decode turns dataPath back into a lossless PNG next to it. PNG input is
+// already lossless, so for PNG Decode only checks that the file decodes.
+// Animated GIFs are reduced to their first frame. Decode never replaces an
+// existing PNG, which may well be the image dataPath was encoded from; use
+// DecodeTo to pick another name.
+func (a ImageCompressionAlgorithm) Decode(dataPath string) error {
+	switch a {
+	case JPEG, GIF, WEBP:
+		dest := withExt(dataPath, ".png")
+		if _, err := os.Lstat(dest); err == nil {
+			return fmt.Errorf("%s: %w", dest, ErrDestinationExists)
+		}
+		return a.DecodeTo(dataPath, dest)
+	case JPEG2000:
+		return fmt.Errorf("JPEG2000 decoding: %w", ErrUnsupportedFormat)
+	case PNG:
+		_, err := decodeImage(dataPath)
+		return err
+	}
+	return nil
+}
+
+// DecodeTo decodes the image at src into a lossless PNG at dest, which must
+// not be src.
+func (a ImageCompressionAlgorithm) DecodeTo(src, dest string) error {
+	switch a {
+	case JPEG, PNG, GIF, WEBP:
+		if err := checkImageDest(src, dest); err != nil {
+			return err
+		}
+		img, err := decodeImage(src)
+		if err != nil {
+			return err
+		}
+		return encodePNG(img, dest, ImageOptions{})
+	case JPEG2000:
+		return fmt.Errorf("JPEG2000 decoding: %w", ErrUnsupportedFormat)
+	}
+	return nil
+}
+
+// loadImage decodes src and applies the resize requested by opts.
+func loadImage(src string, opts ImageOptions) (image.Image, error) {
+	img, err := decodeImage(src)
+	if err != nil {
+		return nil, err
+	}
+
+	w, h := fitSize(img.Bounds().Dx(), img.Bounds().Dy(), opts.MaxWidth, opts.MaxHeight)
+	return resizeImage(img, w, h, opts.Resample), nil
+}
+
+// decodeImage is the single entry point for reading images, so every
+// encoder accepts any input format registered with the image package:
+// JPEG, PNG and GIF always, and WebP when built with cgo and the webp tag.
+func decodeImage(src string) (image.Image, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+func (a ImageCompressionAlgorithm) extension() string {
+	switch a {
+	case JPEG:
+		return ".jpg"
+	case JPEG2000:
+		return ".jp2"
+	case PNG:
+		return ".png"
+	case GIF:
+		return ".gif"
+	case WEBP:
+		return ".webp"
+	}
+	return ""
+}
+
+func lossyQuality(opts ImageOptions) (int, error) {
+	quality := opts.Quality
+	if quality == 0 {
+		quality = jpeg.DefaultQuality
+	}
+	if quality < 1 || quality > 100 {
+		return 0, fmt.Errorf("invalid quality %d, must be between 1 and 100", quality)
+	}
+	return quality, nil
+}
+
+func encodeJPEG(img image.Image, dest string, opts ImageOptions) error {
+	quality, err := lossyQuality(opts)
+	if err != nil {
+		return err
+	}
+
+	f, err := createPending(dest)
+	if err != nil {
+		return err
+	}
+	defer f.discard()
+
+	if err := jpeg.Encode(f, img, &jpeg.Options{Quality: quality}); err != nil {
+		return err
+	}
+	return f.commit()
+}
+
+func encodePNG(img image.Image, dest string, opts ImageOptions) error {
+	f, err := createPending(dest)
+	if err != nil {
+		return err
+	}
+	defer f.discard()
+
+	encoder := png.Encoder{CompressionLevel: opts.CompressionLevel}
+	if err := encoder.Encode(f, img); err != nil {
+		return err
+	}
+	return f.commit()
+}
+
+// encodeGIF dithers the source down to the Plan9 palette. Sources that are
+// already animated GIFs are passed through frame by frame.
+func encodeGIF(src, dest string, opts ImageOptions) error {
+	anim, err := decodeAnimatedGIF(src)
+	if err != nil {
+		return err
+	}
+
+	if anim != nil {
+		resizeGIF(anim, opts)
+	} else {
+		img, err := loadImage(src, opts)
+		if err != nil {
+			return err
+		}
+
+		paletted := image.NewPaletted(img.Bounds(), palette.Plan9)
+		draw.FloydSteinberg.Draw(paletted, img.Bounds(), img, img.Bounds().Min)
+		anim = &gif.GIF{Image: []*image.Paletted{paletted}, Delay: []int{0}}
+	}
+
+	f, err := createPending(dest)
+	if err != nil {
+		return err
+	}
+	defer f.discard()
+
+	if err := gif.EncodeAll(f, anim); err != nil {
+		return err
+	}
+	return f.commit()
+}
+
+// resizeGIF scales every frame of anim by the factor that fits its logical
+// screen within the limits in opts.
+func resizeGIF(anim *gif.GIF, opts ImageOptions) {
+	w, h := fitSize(anim.Config.Width, anim.Config.Height, opts.MaxWidth, opts.MaxHeight)
+	if w == anim.Config.Width && h == anim.Config.Height {
+		return
+	}
+
+	sx := float64(w) / float64(anim.Config.Width)
+	sy := float64(h) / float64(anim.Config.Height)
+	scale := func(v int, s float64) int { return int(math.Round(float64(v) * s)) }
+
+	for i, frame := range anim.Image {
+		b := frame.Bounds()
+		rect := image.Rect(scale(b.Min.X, sx), scale(b.Min.Y, sy), scale(b.Max.X, sx), scale(b.Max.Y, sy))
+		if rect.Empty() {
+			rect.Max = rect.Min.Add(image.Pt(1, 1))
+		}
+
+		resized := resizeImage(frame, rect.Dx(), rect.Dy(), NearestNeighbor)
+		paletted := image.NewPaletted(rect, frame.Palette)
+		draw.Draw(paletted, rect, resized, image.Point{}, draw.Src)
+		anim.Image[i] = paletted
+	}
+	anim.Config.Width, anim.Config.Height = w, h
+}
+
+// decodeAnimatedGIF returns nil if src is not a multi-frame GIF.
+func decodeAnimatedGIF(src string) (*gif.GIF, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if _, format, err := image.DecodeConfig(f); err != nil || format != "gif" {
+		return nil, nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	anim, err := gif.DecodeAll(f)
+	if err != nil {
+		return nil, err
+	}
+	if len(anim.Image) < 2 {
+		return nil, nil
+	}
+	return anim, nil
+}
+
+func withExt(path, ext string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ext
+}
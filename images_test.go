@@ -0,0 +1,339 @@
+package kognit
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// gradient is a w×h image with every pixel different enough to show up
+// any change a lossless encoder makes.
+func gradient(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 255 / w), G: uint8(y * 255 / h), B: uint8((x + y) % 256), A: 255})
+		}
+	}
+	return img
+}
+
+func writePNG(t *testing.T, path string, img image.Image) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// imageConfig decodes the header of the image at path.
+func imageConfig(t *testing.T, path string) (image.Config, string) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	config, format, err := image.DecodeConfig(f)
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return config, format
+}
+
+func TestJPEGRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "photo.png")
+	writePNG(t, src, gradient(40, 30))
+
+	if err := JPEG.Encode(src); err != nil {
+		t.Fatal(err)
+	}
+	config, format := imageConfig(t, filepath.Join(dir, "photo.jpg"))
+	if format != "jpeg" || config.Width != 40 || config.Height != 30 {
+		t.Errorf("photo.jpg is a %dx%d %s, want a 40x30 jpeg", config.Width, config.Height, format)
+	}
+
+	decoded := filepath.Join(dir, "decoded.png")
+	if err := JPEG.DecodeTo(filepath.Join(dir, "photo.jpg"), decoded); err != nil {
+		t.Fatal(err)
+	}
+	config, format = imageConfig(t, decoded)
+	if format != "png" || config.Width != 40 || config.Height != 30 {
+		t.Errorf("decoded.png is a %dx%d %s, want a 40x30 png", config.Width, config.Height, format)
+	}
+}
+
+func TestImageCodecsKeepTheSource(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "photo.png")
+	writePNG(t, src, gradient(8, 8))
+	before, err := os.Stat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := PNG.Encode(src); !errors.Is(err, ErrSameImage) {
+		t.Errorf("PNG.Encode(photo.png) = %v, want ErrSameImage", err)
+	}
+	if err := JPEG.Encode(src); err != nil {
+		t.Fatal(err)
+	}
+	// photo.png is what photo.jpg was made from, so decoding it back must
+	// not replace it.
+	if err := JPEG.Decode(filepath.Join(dir, "photo.jpg")); !errors.Is(err, ErrDestinationExists) {
+		t.Errorf("JPEG.Decode(photo.jpg) = %v, want ErrDestinationExists", err)
+	}
+
+	after, err := os.Stat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(before, after) || after.ModTime() != before.ModTime() || after.Size() != before.Size() {
+		t.Error("photo.png was replaced")
+	}
+}
+
+// readImage decodes the image at path.
+func readImage(t *testing.T, path string) image.Image {
+	t.Helper()
+	img, err := decodeImage(path)
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return img
+}
+
+// samePixels reports the first pixel where a and b differ.
+func samePixels(a, b image.Image) (image.Point, bool) {
+	if a.Bounds() != b.Bounds() {
+		return a.Bounds().Max, false
+	}
+	r := a.Bounds()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if color.RGBA64Model.Convert(a.At(x, y)) != color.RGBA64Model.Convert(b.At(x, y)) {
+				return image.Pt(x, y), false
+			}
+		}
+	}
+	return image.Point{}, true
+}
+
+func TestPNGIsLossless(t *testing.T) {
+	dir := t.TempDir()
+	want := gradient(64, 48)
+	src := filepath.Join(dir, "source.png")
+	writePNG(t, src, want)
+
+	for _, level := range []png.CompressionLevel{png.DefaultCompression, png.NoCompression, png.BestSpeed, png.BestCompression} {
+		dest := filepath.Join(dir, "out.png")
+		if err := PNG.EncodeTo(src, dest, ImageOptions{CompressionLevel: level}); err != nil {
+			t.Fatal(err)
+		}
+		if p, ok := samePixels(readImage(t, dest), want); !ok {
+			t.Errorf("level %d: pixel %v differs from the source", level, p)
+		}
+		os.Remove(dest)
+	}
+
+	// A JPEG source is decoded once and then kept exactly.
+	if err := JPEG.Encode(src); err != nil {
+		t.Fatal(err)
+	}
+	photo := filepath.Join(dir, "source.jpg")
+	dest := filepath.Join(dir, "from-jpeg.png")
+	if err := PNG.EncodeTo(photo, dest, ImageOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := samePixels(readImage(t, dest), readImage(t, photo)); !ok {
+		t.Errorf("from JPEG: pixel %v differs from the decoded source", p)
+	}
+}
+
+func readGIF(t *testing.T, path string) *gif.GIF {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	anim, err := gif.DecodeAll(f)
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return anim
+}
+
+func TestGIFHasAtMost256Colours(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "gradient.png")
+	writePNG(t, src, gradient(64, 48))
+
+	if err := GIF.Encode(src); err != nil {
+		t.Fatal(err)
+	}
+	anim := readGIF(t, filepath.Join(dir, "gradient.gif"))
+	if len(anim.Image) != 1 {
+		t.Fatalf("%d frames, want 1", len(anim.Image))
+	}
+	frame := anim.Image[0]
+	if frame.Bounds() != image.Rect(0, 0, 64, 48) {
+		t.Errorf("frame bounds %v, want 64x48", frame.Bounds())
+	}
+	if len(frame.Palette) > 256 {
+		t.Errorf("palette has %d colours", len(frame.Palette))
+	}
+	used := map[color.Color]bool{}
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			used[frame.At(x, y)] = true
+		}
+	}
+	// The source has far more than 256 colours; dithering should still use
+	// a good part of the palette rather than collapsing to a few.
+	if len(used) > 256 || len(used) < 16 {
+		t.Errorf("frame uses %d colours", len(used))
+	}
+}
+
+func TestGIFKeepsAnimation(t *testing.T) {
+	dir := t.TempDir()
+	anim := &gif.GIF{}
+	for i, c := range []color.Color{color.White, color.Black, color.RGBA{R: 255, A: 255}} {
+		frame := image.NewPaletted(image.Rect(0, 0, 10, 10), color.Palette{color.White, color.Black, color.RGBA{R: 255, A: 255}})
+		draw.Draw(frame, frame.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, 10*(i+1))
+	}
+	src := filepath.Join(dir, "anim.gif")
+	f, err := os.Create(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gif.EncodeAll(f, anim); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	dest := filepath.Join(dir, "copy.gif")
+	if err := GIF.EncodeTo(src, dest, ImageOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	got := readGIF(t, dest)
+	if len(got.Image) != 3 || got.Delay[0] != 10 || got.Delay[2] != 30 {
+		t.Errorf("%d frames with delays %v, want 3 with [10 20 30]", len(got.Image), got.Delay)
+	}
+}
+
+func TestEncodeReport(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "photo.png")
+	writePNG(t, src, gradient(120, 80))
+	original, err := os.Stat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, quality := range []int{0, 40} {
+		report, err := JPEG.EncodeReportWithOptions(src, ImageOptions{Quality: quality})
+		if err != nil {
+			t.Fatal(err)
+		}
+		written, err := os.Stat(filepath.Join(dir, "photo.jpg"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := ImageReport{
+			OriginalBytes:   original.Size(),
+			CompressedBytes: written.Size(),
+			Width:           120,
+			Height:          80,
+			Quality:         quality,
+		}
+		if quality == 0 {
+			want.Quality = jpeg.DefaultQuality
+		}
+		if report != want {
+			t.Errorf("quality %d: report %+v, want %+v", quality, report, want)
+		}
+	}
+}
+
+func TestJPEG2000Unsupported(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "photo.png")
+	writePNG(t, src, gradient(8, 8))
+	writeTree(t, dir, map[string]string{"scan.jp2": "not decoded anyway"})
+	scan := filepath.Join(dir, "scan.jp2")
+
+	for name, run := range map[string]func() error{
+		"Encode":       func() error { return JPEG2000.Encode(src) },
+		"EncodeTo":     func() error { return JPEG2000.EncodeTo(src, filepath.Join(dir, "out.jp2"), ImageOptions{}) },
+		"EncodeReport": func() error { _, err := JPEG2000.EncodeReport(src); return err },
+		"CompressItem": func() error { return CompressItem(src, Image, "jpeg2000") },
+		"Decode":       func() error { return JPEG2000.Decode(scan) },
+		"DecodeTo":     func() error { return JPEG2000.DecodeTo(scan, filepath.Join(dir, "scan-out.png")) },
+	} {
+		if err := run(); !errors.Is(err, ErrUnsupportedFormat) {
+			t.Errorf("%s = %v, want ErrUnsupportedFormat", name, err)
+		}
+	}
+	for _, name := range []string{"photo.jp2", "out.jp2", "scan.png", "scan-out.png"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s was written: %v", name, err)
+		}
+	}
+}
+
+func TestImageFormatMatrix(t *testing.T) {
+	dir := t.TempDir()
+	img := gradient(24, 16)
+	inputs := map[string]func(io.Writer) error{
+		"png":  func(w io.Writer) error { return png.Encode(w, img) },
+		"jpeg": func(w io.Writer) error { return jpeg.Encode(w, img, nil) },
+		"gif":  func(w io.Writer) error { return gif.Encode(w, img, nil) },
+	}
+	outputs := map[string]ImageCompressionAlgorithm{"png": PNG, "jpeg": JPEG, "gif": GIF, "webp": WEBP}
+
+	for in, encode := range inputs {
+		src := filepath.Join(dir, "in-"+in)
+		f, err := os.Create(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := encode(f); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+
+		for out, algo := range outputs {
+			dest := filepath.Join(dir, in+"-to"+algo.extension())
+			err := algo.EncodeTo(src, dest, ImageOptions{})
+			// WebP output needs the webp build tag.
+			if algo == WEBP && errors.Is(err, ErrUnsupportedFormat) {
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s to %s: %v", in, out, err)
+				continue
+			}
+			if config, format := imageConfig(t, dest); format != out || config.Width != 24 || config.Height != 16 {
+				t.Errorf("%s to %s gave a %dx%d %s", in, out, config.Width, config.Height, format)
+			}
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package kognit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ItemType is the kind of thing being compressed, which decides the family
+// of algorithms that can handle it.
+type ItemType int
+
+const (
+	Directory ItemType = iota
+	File
+	Image
+)
+
+func (it ItemType) String() string {
+	switch it {
+	case Directory:
+		return "directory"
+	case File:
+		return "file"
+	case Image:
+		return "image"
+	}
+	return fmt.Sprintf("ItemType(%d)", int(it))
+}
+
+// imageExtensions backs up content sniffing for formats that
+// http.DetectContentType does not know, such as JPEG 2000.
+var imageExtensions = map[string]bool{
+	".jpg": true, ".jpeg": true, ".jp2": true, ".j2k": true, ".png": true, ".gif": true, ".webp": true, ".bmp": true,
+}
+
+// DetectItemType reports whether path is a directory, an image or any other
+// file. Images are recognised from their first 512 bytes, falling back to
+// the extension.
+func DetectItemType(path string) (ItemType, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	if info.IsDir() {
+		return Directory, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return 0, err
+	}
+
+	if strings.HasPrefix(http.DetectContentType(head[:n]), "image/") || imageExtensions[strings.ToLower(filepath.Ext(path))] {
+		return Image, nil
+	}
+	return File, nil
+}
+
+var ErrAlgorithmMismatch = errors.New("algorithm does not apply to this item type")
+
+// Algorithm names, as accepted by CompressItem, for each family.
+var (
+	directoryAlgorithms = map[string]DirectoryCompressionAlgorithm{
+		"zip": ZIP, "tar": TAR, "zstd": ZSTD, "bzip2": BZIP2,
+	}
+	fileAlgorithms = map[string]FileCompressionAlgorithm{
+		"flate": Flate, "deflate": Deflate, "gzip": Gzip, "huffman": Huffman, "lzw": LZW, "rle": RLE,
+	}
+	imageAlgorithms = map[string]ImageCompressionAlgorithm{
+		"jpeg": JPEG, "jpeg2000": JPEG2000, "png": PNG, "gif": GIF, "webp": WEBP,
+	}
+)
+
+// CompressItem encodes path with the algorithm called algoName, which must
+// belong to the family for it. Algorithms added with RegisterAlgorithm have
+// no family and are accepted for any item type. Names are case-insensitive.
+func CompressItem(path string, it ItemType, algoName string) error {
+	algo, err := lookupAlgorithm(it, algoName)
+	if err != nil {
+		return err
+	}
+	return algo.Encode(path)
+}
+
+func lookupAlgorithm(it ItemType, name string) (CompressionAlgorithm, error) {
+	name = strings.ToLower(name)
+
+	dir, isDir := directoryAlgorithms[name]
+	file, isFile := fileAlgorithms[name]
+	img, isImage := imageAlgorithms[name]
+
+	switch {
+	case it == Directory && isDir:
+		return dir, nil
+	case it == File && isFile:
+		return file, nil
+	case it == Image && isImage:
+		return img, nil
+	case isDir || isFile || isImage:
+		return nil, fmt.Errorf("%s for %s: %w", name, it, ErrAlgorithmMismatch)
+	}
+	if a, ok := registeredAlgorithm(name); ok {
+		return a, nil
+	}
+	return nil, fmt.Errorf("%s: %w", name, ErrUnsupportedAlgorithm)
+}
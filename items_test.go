@@ -0,0 +1,88 @@
+package kognit
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompressItem(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	writeTree(t, src, map[string]string{"a.txt": "alpha"})
+	photo := filepath.Join(dir, "photo.png")
+	writePNG(t, photo, gradient(8, 8))
+	text := filepath.Join(dir, "notes.txt")
+	writeTree(t, dir, map[string]string{"notes.txt": "plain text"})
+
+	for _, tt := range []struct {
+		name string
+		path string
+		it   ItemType
+		algo string
+		want string
+		err  error
+	}{
+		{"directory", src, Directory, "zip", src + ".zip", nil},
+		{"directory any case", src, Directory, "TAR", src + ".tar.gz", nil},
+		{"image", photo, Image, "jpeg", filepath.Join(dir, "photo.jpg"), nil},
+		{"file stub", text, File, "gzip", "", ErrUnsupportedAlgorithm},
+		{"image on directory", src, Directory, "png", "", ErrAlgorithmMismatch},
+		{"directory on file", text, File, "zip", "", ErrAlgorithmMismatch},
+		{"file on image", photo, Image, "lzw", "", ErrAlgorithmMismatch},
+		{"unknown", src, Directory, "rar", "", ErrUnsupportedAlgorithm},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CompressItem(tt.path, tt.it, tt.algo)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("CompressItem(%s, %s, %q) = %v, want %v", filepath.Base(tt.path), tt.it, tt.algo, err, tt.err)
+			}
+			if tt.want == "" {
+				return
+			}
+			if _, err := os.Stat(tt.want); err != nil {
+				t.Errorf("no output: %v", err)
+			}
+		})
+	}
+}
+
+func TestDetectItemType(t *testing.T) {
+	dir := t.TempDir()
+	writePNG(t, filepath.Join(dir, "photo.png"), gradient(8, 8))
+	if err := JPEG.Encode(filepath.Join(dir, "photo.png")); err != nil {
+		t.Fatal(err)
+	}
+	// Content decides, so an image without its extension is still one.
+	if err := os.Rename(filepath.Join(dir, "photo.jpg"), filepath.Join(dir, "photo-jpeg")); err != nil {
+		t.Fatal(err)
+	}
+	writeTree(t, dir, map[string]string{
+		"notes.txt": "just some text\n",
+		"scan.jp2":  "not sniffable, but named as an image",
+		"empty.dat": "",
+		"sub/a.txt": "a",
+	})
+
+	for name, want := range map[string]ItemType{
+		"sub":        Directory,
+		".":          Directory,
+		"photo.png":  Image,
+		"photo-jpeg": Image,
+		"scan.jp2":   Image,
+		"notes.txt":  File,
+		"empty.dat":  File,
+	} {
+		got, err := DetectItemType(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if got != want {
+			t.Errorf("DetectItemType(%s) = %s, want %s", name, got, want)
+		}
+	}
+
+	if _, err := DetectItemType(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Errorf("DetectItemType(missing) = %v, want a not-exist error", err)
+	}
+}
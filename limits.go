@@ -0,0 +1,130 @@
+package kognit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+var (
+	ErrSizeLimitExceeded = errors.New("archive entry exceeds the configured size limit")
+	ErrRatioExceeded     = errors.New("archive entry exceeds the configured compression ratio")
+	ErrTooManyEntries    = errors.New("archive has more entries than the configured limit")
+)
+
+// extraction carries the options and running totals of a single decode.
+type extraction struct {
+	opts  ArchiveOptions
+	total int64
+	// entries counts the entries extracted so far, for MaxEntries.
+	entries int
+
+	// match, when set, restricts the decode to the entries it accepts.
+	match   func(name string) bool
+	matched int
+	// links maps hard link targets the match left out to the paths of the
+	// extracted links waiting for their content.
+	links map[string][]string
+
+	result DecodeResult
+
+	// stream counts the compressed bytes read from a tar-based archive,
+	// whose entries have no compressed size of their own.
+	stream *countingReader
+
+	// checksums maps zip entry names to the SHA-256 recorded for them.
+	checksums map[string]string
+}
+
+func (x *extraction) wants(name string) bool {
+	if x.match == nil {
+		return true
+	}
+	if !x.match(name) {
+		return false
+	}
+	x.matched++
+	return true
+}
+
+// count admits one more entry, failing once MaxEntries is exceeded so the
+// decode stops before writing it.
+func (x *extraction) count(name string) error {
+	x.entries++
+	if x.opts.MaxEntries > 0 && x.entries > x.opts.MaxEntries {
+		return fmt.Errorf("%s: %w", name, ErrTooManyEntries)
+	}
+	return nil
+}
+
+// reserve rejects an entry up front when its declared size already breaks
+// one of the limits. Declared sizes can lie, so limit still counts the bytes
+// actually written.
+func (x *extraction) reserve(path string, size int64) error {
+	if x.opts.MaxFileBytes > 0 && size > x.opts.MaxFileBytes {
+		return fmt.Errorf("%s: %w", path, ErrSizeLimitExceeded)
+	}
+	// zipSize clamps hostile sizes to MaxInt64, so adding it to the total
+	// could overflow.
+	if x.opts.MaxTotalBytes > 0 && size > x.opts.MaxTotalBytes-x.total {
+		return fmt.Errorf("%s: %w", path, ErrSizeLimitExceeded)
+	}
+	return nil
+}
+
+// limit counts the bytes read from r against the size and ratio limits.
+// packed is the entry's compressed size, or -1 when only the ratio of the
+// whole stream can be checked.
+func (x *extraction) limit(r io.Reader, path string, packed int64) io.Reader {
+	return &limitedReader{r: r, x: x, path: path, packed: packed}
+}
+
+type limitedReader struct {
+	r      io.Reader
+	x      *extraction
+	path   string
+	n      int64
+	packed int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	l.x.total += int64(n)
+
+	opts := l.x.opts
+	if opts.MaxFileBytes > 0 && l.n > opts.MaxFileBytes {
+		return n, fmt.Errorf("%s: %w", l.path, ErrSizeLimitExceeded)
+	}
+	if opts.MaxTotalBytes > 0 && l.x.total > opts.MaxTotalBytes {
+		return n, fmt.Errorf("%s: %w", l.path, ErrSizeLimitExceeded)
+	}
+	if opts.MaxRatio > 0 {
+		written, packed := l.n, l.packed
+		if packed < 0 && l.x.stream != nil {
+			written, packed = l.x.total, l.x.stream.count()
+		}
+		if packed >= 0 && written > packed*int64(opts.MaxRatio) {
+			return n, fmt.Errorf("%s: %w", l.path, ErrRatioExceeded)
+		}
+	}
+	return n, err
+}
+
+// countingReader counts the bytes read through it. The zstd decoder reads
+// ahead from its own goroutine, so the count is kept atomically.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
+
+func (c *countingReader) count() int64 {
+	return atomic.LoadInt64(&c.n)
+}
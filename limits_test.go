@@ -0,0 +1,197 @@
+package kognit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type zip64Entry struct {
+	name    string
+	content string
+	// declared is the uncompressed size the central directory claims,
+	// always through a zip64 extra field.
+	declared uint64
+}
+
+// writeZip64 hand-assembles a zip of stored entries whose central directory
+// records declared sizes. archive/zip before Go 1.17 cannot write entries
+// with a size of our choosing.
+func writeZip64(t *testing.T, path string, entries []zip64Entry) {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	le := func(v interface{}) { binary.Write(buf, binary.LittleEndian, v) }
+
+	offsets := []uint32{}
+	for _, e := range entries {
+		offsets = append(offsets, uint32(buf.Len()))
+		le(uint32(0x04034b50))
+		le([]uint16{45, 0, 0, 0, 0x21})
+		le([]uint32{crc32.ChecksumIEEE([]byte(e.content)), uint32(len(e.content)), uint32(len(e.content))})
+		le([]uint16{uint16(len(e.name)), 0})
+		buf.WriteString(e.name)
+		buf.WriteString(e.content)
+	}
+
+	dirStart := buf.Len()
+	for i, e := range entries {
+		le(uint32(0x02014b50))
+		le([]uint16{3<<8 | 45, 45, 0, 0, 0, 0x21})
+		le([]uint32{crc32.ChecksumIEEE([]byte(e.content)), uint32(len(e.content)), math.MaxUint32})
+		le([]uint16{uint16(len(e.name)), 12, 0, 0, 0})
+		le([]uint32{0644 << 16, offsets[i]})
+		buf.WriteString(e.name)
+		le([]uint16{0x0001, 8})
+		le(e.declared)
+	}
+	dirEnd := buf.Len()
+
+	le(uint32(0x06054b50))
+	le([]uint16{0, 0, uint16(len(entries)), uint16(len(entries))})
+	le([]uint32{uint32(dirEnd - dirStart), uint32(dirStart)})
+	le(uint16(0))
+
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestZip64DeclaredSize(t *testing.T) {
+	const huge = 5 << 30 // past what 32 bits can hold
+	archive := filepath.Join(t.TempDir(), "zip64.zip")
+	writeZip64(t, archive, []zip64Entry{{name: "huge.bin", content: "tiny", declared: huge}})
+
+	entries, err := ListArchive(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Size != huge {
+		t.Errorf("ListArchive = %+v, want one entry of %d bytes", entries, int64(huge))
+	}
+	size, err := UncompressedSize(archive, ZIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != huge {
+		t.Errorf("UncompressedSize = %d, want %d", size, int64(huge))
+	}
+
+	dest := filepath.Join(t.TempDir(), "out")
+	err = NewArchiver(ZIP, WithMaxFileBytes(1<<30)).Decode(archive, dest)
+	if !errors.Is(err, ErrSizeLimitExceeded) {
+		t.Errorf("Decode = %v, want ErrSizeLimitExceeded", err)
+	}
+	assertTree(t, dest, map[string]string{})
+}
+
+// A size past MaxInt64 is clamped to it, which must not wrap the running
+// total around and slip under MaxTotalBytes.
+func TestZip64ClampedSizeHitsTotalLimit(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "zip64.zip")
+	writeZip64(t, archive, []zip64Entry{
+		{name: "first.txt", content: "abc", declared: 3},
+		{name: "hostile.bin", content: "tiny", declared: math.MaxUint64},
+	})
+
+	dest := filepath.Join(t.TempDir(), "out")
+	err := NewArchiver(ZIP, WithMaxTotalBytes(1<<20)).Decode(archive, dest)
+	if !errors.Is(err, ErrSizeLimitExceeded) {
+		t.Errorf("Decode = %v, want ErrSizeLimitExceeded", err)
+	}
+	assertTree(t, dest, map[string]string{"first.txt": "abc"})
+}
+
+func TestMaxEntriesStopsExtraction(t *testing.T) {
+	entries := map[string][]byte{}
+	for i := 0; i < 500; i++ {
+		entries[fmt.Sprintf("f%03d.txt", i)] = []byte("tiny")
+	}
+
+	for _, algo := range []DirectoryCompressionAlgorithm{ZIP, TAR} {
+		dir := t.TempDir()
+		archive := writeArchive(t, dir, algo, entries)
+		out := filepath.Join(dir, "out")
+		err := NewArchiver(algo, WithMaxEntries(10)).Decode(archive, out)
+		if !errors.Is(err, ErrTooManyEntries) {
+			t.Errorf("%s: Decode = %v, want ErrTooManyEntries", algo.extension(), err)
+		}
+		if n := len(readTree(t, out)); n > 10 {
+			t.Errorf("%s: %d files written past a limit of 10", algo.extension(), n)
+		}
+	}
+}
+
+func TestCompressibleBombHitsSizeLimits(t *testing.T) {
+	bomb := map[string][]byte{"zeros.bin": make([]byte, 32<<20)}
+	spread := map[string][]byte{}
+	for i := 0; i < 8; i++ {
+		spread[fmt.Sprintf("part%d.bin", i)] = make([]byte, 512<<10)
+	}
+
+	for _, algo := range []DirectoryCompressionAlgorithm{ZIP, TAR, ZSTD} {
+		for _, tt := range []struct {
+			name    string
+			entries map[string][]byte
+			opt     Option
+		}{
+			{"one large entry", bomb, WithMaxFileBytes(1 << 20)},
+			{"one large entry", bomb, WithMaxTotalBytes(1 << 20)},
+			{"many entries", spread, WithMaxTotalBytes(1 << 20)},
+		} {
+			dir := t.TempDir()
+			archive := writeArchive(t, dir, algo, tt.entries)
+			info, err := os.Stat(archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() > 1<<20 {
+				t.Fatalf("%s: the %s archive is %d bytes, too big to be a bomb", algo.extension(), tt.name, info.Size())
+			}
+
+			out := filepath.Join(dir, "out")
+			err = NewArchiver(algo, tt.opt).Decode(archive, out)
+			if !errors.Is(err, ErrSizeLimitExceeded) {
+				t.Errorf("%s, %s: Decode = %v, want ErrSizeLimitExceeded", algo.extension(), tt.name, err)
+			}
+			written := 0
+			for _, content := range readTree(t, out) {
+				written += len(content)
+			}
+			if written > 1<<20 {
+				t.Errorf("%s, %s: %d bytes written past a 1 MiB limit", algo.extension(), tt.name, written)
+			}
+		}
+	}
+}
+
+func TestMaxRatioStopsBomb(t *testing.T) {
+	bomb := map[string][]byte{"zeros.bin": make([]byte, 16<<20)}
+	text := map[string][]byte{"text.txt": []byte(wordSoup(256 << 10))}
+
+	for _, algo := range []DirectoryCompressionAlgorithm{ZIP, TAR, ZSTD} {
+		dir := t.TempDir()
+		out := filepath.Join(dir, "out")
+		archive := writeArchive(t, dir, algo, bomb)
+		err := NewArchiver(algo, WithMaxRatio(100)).Decode(archive, out)
+		if !errors.Is(err, ErrRatioExceeded) {
+			t.Errorf("%s: Decode of a bomb = %v, want ErrRatioExceeded", algo.extension(), err)
+		}
+		if got := readTree(t, out)["zeros.bin"]; len(got) == len(bomb["zeros.bin"]) {
+			t.Errorf("%s: the whole bomb was written", algo.extension())
+		}
+
+		// Ordinary text stays well inside the ratio.
+		dir = t.TempDir()
+		archive = writeArchive(t, dir, algo, text)
+		if err := NewArchiver(algo, WithMaxRatio(100)).Decode(archive, filepath.Join(dir, "out")); err != nil {
+			t.Errorf("%s: Decode of text = %v", algo.extension(), err)
+		}
+	}
+}
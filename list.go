@@ -0,0 +1,186 @@
+package kognit
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"time"
+)
+
+type ArchiveEntry struct {
+	Name    string
+	Size    int64
+	Mode    os.FileMode
+	ModTime time.Time
+	// Method is how the entry's data is compressed: "Store" or "Deflate"
+	// for zip entries, and the archive's stream compression for tar.
+	Method string
+}
+
+// ListArchive returns the entries stored in src without extracting them.
+// The archive format is detected the same way as in DecodeAuto. The list of
+// checksums a zip may end with is not one of its entries.
+func ListArchive(src string) ([]ArchiveEntry, error) {
+	a, err := detectArchiveFormat(src)
+	if err != nil {
+		return nil, err
+	}
+
+	switch a {
+	case ZIP:
+		return listZipArchive(src)
+	case TAR, ZSTD, BZIP2:
+		return listTarArchive(src, a)
+	}
+	return nil, nil
+}
+
+func listZipArchive(src string) ([]ArchiveEntry, error) {
+	archive, size, err := openArchive(src)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	r, err := zip.NewReader(archive, size)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []ArchiveEntry{}
+	for _, f := range r.File {
+		if f.Name == zipChecksumsName {
+			continue
+		}
+		entries = append(entries, ArchiveEntry{
+			Name:    f.Name,
+			Size:    zipSize(f.UncompressedSize64),
+			Mode:    f.Mode(),
+			ModTime: f.Modified,
+			Method:  zipMethodName(f.Method),
+		})
+	}
+	return entries, nil
+}
+
+func zipMethodName(method uint16) string {
+	switch method {
+	case zip.Store:
+		return "Store"
+	case zip.Deflate:
+		return "Deflate"
+	}
+	return fmt.Sprintf("method %d", method)
+}
+
+func listTarArchive(src string, a DirectoryCompressionAlgorithm) ([]ArchiveEntry, error) {
+	stream, _, err := openArchive(src)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	dr, err := a.decompressor(stream)
+	if err != nil {
+		return nil, err
+	}
+	defer dr.Close()
+
+	r := tar.NewReader(dr)
+
+	entries := []ArchiveEntry{}
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, ArchiveEntry{
+			Name:    header.Name,
+			Size:    header.Size,
+			Mode:    header.FileInfo().Mode(),
+			ModTime: header.ModTime,
+			Method:  a.streamName(),
+		})
+	}
+	return entries, nil
+}
+
+// UncompressedSize reports how many bytes the regular files in src take up
+// once extracted. Zip sizes come from the central directory; tar archives
+// are streamed and their payloads counted.
+func UncompressedSize(src string, algo DirectoryCompressionAlgorithm) (int64, error) {
+	switch algo {
+	case ZIP:
+		archive, size, err := openArchive(src)
+		if err != nil {
+			return 0, err
+		}
+		defer archive.Close()
+
+		r, err := zip.NewReader(archive, size)
+		if err != nil {
+			return 0, err
+		}
+
+		total := int64(0)
+		for _, f := range r.File {
+			if f.FileInfo().IsDir() || f.Name == zipChecksumsName {
+				continue
+			}
+			size := zipSize(f.UncompressedSize64)
+			if total > math.MaxInt64-size {
+				return math.MaxInt64, nil
+			}
+			total += size
+		}
+		return total, nil
+	case TAR, ZSTD, BZIP2:
+		return tarPayloadSize(src, algo)
+	}
+	return 0, algo.unsupported()
+}
+
+func tarPayloadSize(src string, a DirectoryCompressionAlgorithm) (int64, error) {
+	stream, _, err := openArchive(src)
+	if err != nil {
+		return 0, err
+	}
+	defer stream.Close()
+
+	dr, err := a.decompressor(stream)
+	if err != nil {
+		return 0, err
+	}
+	defer dr.Close()
+
+	r := tar.NewReader(dr)
+
+	total := int64(0)
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+
+		n, err := io.Copy(ioutil.Discard, r)
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
@@ -0,0 +1,125 @@
+package kognit
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestListArchive(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	files := map[string]string{
+		"a.txt":       "alpha",
+		"sub/b.txt":   strings.Repeat("bravo", 100),
+		"sub/d/e.txt": "",
+	}
+	writeTree(t, src, files)
+	modes := map[string]os.FileMode{"a.txt": 0644, "sub/b.txt": 0600, "sub/d/e.txt": 0640}
+	for name, mode := range modes {
+		if err := os.Chmod(filepath.Join(src, filepath.FromSlash(name)), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, algo := range []DirectoryCompressionAlgorithm{ZIP, TAR, ZSTD} {
+		archive := filepath.Join(dir, "list"+algo.extension())
+		if err := NewArchiver(algo).Encode(src, archive); err != nil {
+			t.Fatal(err)
+		}
+		entries, err := ListArchive(archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		listed := map[string]ArchiveEntry{}
+		for _, e := range entries {
+			if e.Mode.IsRegular() {
+				listed[strings.TrimPrefix(e.Name, filepath.ToSlash(src)+"/")] = e
+			}
+		}
+		if len(listed) != len(files) {
+			t.Errorf("%s lists %d files, want %d: %v", algo.extension(), len(listed), len(files), entries)
+		}
+		for name, content := range files {
+			e, ok := listed[name]
+			if !ok {
+				t.Errorf("%s: %s not listed", algo.extension(), name)
+				continue
+			}
+			if e.Size != int64(len(content)) || e.Mode.Perm() != modes[name] {
+				t.Errorf("%s: %s listed with size %d and mode %v, want %d and %v", algo.extension(), name, e.Size, e.Mode.Perm(), len(content), modes[name])
+			}
+		}
+	}
+}
+
+func TestUncompressedSizeSumsFiles(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	writeTree(t, src, map[string]string{
+		"a.txt":       strings.Repeat("alpha", 3000),
+		"sub/b.txt":   "bravo",
+		"sub/d/e.txt": "",
+		"sub/d/f.bin": strings.Repeat("\x00", 70000),
+	})
+	var want int64
+	filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			want += info.Size()
+		}
+		return err
+	})
+
+	for _, algo := range []DirectoryCompressionAlgorithm{ZIP, TAR, ZSTD} {
+		archive := filepath.Join(dir, "size"+algo.extension())
+		if err := NewArchiver(algo).Encode(src, archive); err != nil {
+			t.Fatal(err)
+		}
+		size, err := UncompressedSize(archive, algo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size != want {
+			t.Errorf("%s: UncompressedSize = %d, want %d", algo.extension(), size, want)
+		}
+	}
+}
+
+func TestListArchiveMethods(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	writeTree(t, src, map[string]string{"notes.txt": strings.Repeat("notes ", 100)})
+	writePNG(t, filepath.Join(src, "photo.png"), gradient(16, 16))
+
+	for _, tt := range []struct {
+		algo DirectoryCompressionAlgorithm
+		want map[string]string
+	}{
+		{ZIP, map[string]string{"notes.txt": "Deflate", "photo.png": "Store"}},
+		{TAR, map[string]string{"notes.txt": "gzip", "photo.png": "gzip"}},
+		{ZSTD, map[string]string{"notes.txt": "zstd", "photo.png": "zstd"}},
+	} {
+		archive := filepath.Join(dir, "methods"+tt.algo.extension())
+		for _, concurrency := range []int{1, 4} {
+			if err := NewArchiver(tt.algo, WithConcurrency(concurrency)).Encode(src, archive); err != nil {
+				t.Fatal(err)
+			}
+			entries, err := ListArchive(archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			methods := map[string]string{}
+			for _, e := range entries {
+				if e.Mode.IsRegular() {
+					methods[filepath.Base(e.Name)] = e.Method
+				}
+			}
+			if !reflect.DeepEqual(methods, tt.want) {
+				t.Errorf("%s with %d workers: methods %v, want %v", tt.algo.extension(), concurrency, methods, tt.want)
+			}
+		}
+	}
+}
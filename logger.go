@@ -0,0 +1,38 @@
+package kognit
+
+import "sync"
+
+// Logger receives the package's diagnostic messages. *log.Logger satisfies
+// it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Printf(string, ...interface{}) {}
+
+var (
+	loggerMu sync.RWMutex
+	logger   Logger = nopLogger{}
+)
+
+// SetLogger sends the package's diagnostic messages, such as the notes the
+// file algorithms print and the warnings about files that change while
+// being archived, to l. By default they are discarded; a nil l restores
+// that.
+func SetLogger(l Logger) {
+	if l == nil {
+		l = nopLogger{}
+	}
+	loggerMu.Lock()
+	logger = l
+	loggerMu.Unlock()
+}
+
+func logf(format string, v ...interface{}) {
+	loggerMu.RLock()
+	l := logger
+	loggerMu.RUnlock()
+	l.Printf(format, v...)
+}
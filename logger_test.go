@@ -0,0 +1,70 @@
+package kognit
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingLogger keeps every message it is given.
+type recordingLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *recordingLogger) Printf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, fmt.Sprintf(format, v...))
+}
+
+func TestSetLoggerCapturesMessages(t *testing.T) {
+	rec := &recordingLogger{}
+	SetLogger(rec)
+	defer SetLogger(nil)
+
+	Gzip.Encode("unused")
+	RLE.Decode("unused")
+
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "alpha"})
+	gone := filepath.Join(dir, "gone.txt")
+	files := []archiveFile{
+		{path: filepath.Join(dir, "a.txt"), name: "a.txt"},
+		{path: gone, name: "gone.txt"},
+	}
+	if err := TAR.encode(files, filepath.Join(dir, "out.tar.gz"), ArchiveOptions{SkipVanished: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"File encoding using Gzip",
+		"File decoding using RLE",
+		"kognit: skipping " + gone + ": file vanished during archiving",
+	}
+	if strings.Join(rec.messages, "\n") != strings.Join(want, "\n") {
+		t.Errorf("logged %q, want %q", rec.messages, want)
+	}
+
+	// A nil logger discards messages again.
+	SetLogger(nil)
+	Gzip.Encode("unused")
+	if len(rec.messages) != len(want) {
+		t.Errorf("logged after SetLogger(nil): %q", rec.messages[len(want):])
+	}
+}
+
+func TestSetLoggerStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	SetLogger(log.New(&buf, "", 0))
+	defer SetLogger(nil)
+
+	Flate.Encode("unused")
+	if got := buf.String(); got != "File encoding using Flate\n" {
+		t.Errorf("log.Logger got %q", got)
+	}
+}
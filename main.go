@@ -1,8 +1,6 @@
 package kognit
 
-import (
-	"fmt"
-)
+import "fmt"
 
 type DirectoryCompressionAlgorithm int
 type FileCompressionAlgorithm int
@@ -25,70 +23,41 @@ const (
 func (a FileCompressionAlgorithm) Encode(dataPath string) error {
 	switch a {
 	case Flate:
-		fmt.Println("File encoding using Flate")
+		logf("File encoding using Flate")
 	case Deflate:
-		fmt.Println("File encoding using Deflate")
+		logf("File encoding using Deflate")
 	case Gzip:
-		fmt.Println("File encoding using Gzip")
+		logf("File encoding using Gzip")
 	case Huffman:
-		fmt.Println("File encoding using Huffman")
+		logf("File encoding using Huffman")
 	case LZW:
-		fmt.Println("File encoding using LZW")
+		logf("File encoding using LZW")
 	case RLE:
-		fmt.Println("File encoding using RLE")
+		logf("File encoding using RLE")
 	}
-	return nil
+	return a.unsupported()
 }
 
 func (a FileCompressionAlgorithm) Decode(dataPath string) error {
 	switch a {
 	case Flate:
-		fmt.Println("File decoding using Flate")
+		logf("File decoding using Flate")
 	case Deflate:
-		fmt.Println("File decoding using Deflate")
+		logf("File decoding using Deflate")
 	case Gzip:
-		fmt.Println("File decoding using Gzip")
+		logf("File decoding using Gzip")
 	case Huffman:
-		fmt.Println("File decoding using Huffman")
+		logf("File decoding using Huffman")
 	case LZW:
-		fmt.Println("File decoding using LZW")
+		logf("File decoding using LZW")
 	case RLE:
-		fmt.Println("File decoding using RLE")
-	}
-	return nil
-}
-
-const (
-	JPEG ImageCompressionAlgorithm = iota
-	JPEG2000
-	PNG
-	GIF
-)
-
-func (a ImageCompressionAlgorithm) Encode(dataPath string) error {
-	switch a {
-	case JPEG:
-		fmt.Println("File encoding using JPEG")
-	case JPEG2000:
-		fmt.Println("File encoding using JPEG2000")
-	case PNG:
-		fmt.Println("File encoding using PNG")
-	case GIF:
-		fmt.Println("File encoding using GIF")
+		logf("File decoding using RLE")
 	}
-	return nil
+	return a.unsupported()
 }
 
-func (a ImageCompressionAlgorithm) Decode(dataPath string) error {
-	switch a {
-	case JPEG:
-		fmt.Println("File decoding using JPEG")
-	case JPEG2000:
-		fmt.Println("File decoding using JPEG2000")
-	case PNG:
-		fmt.Println("File decoding using PNG")
-	case GIF:
-		fmt.Println("File decoding using GIF")
-	}
-	return nil
+// unsupported reports that a has no implementation yet, which is so for
+// every file algorithm, rather than claiming success without doing anything.
+func (a FileCompressionAlgorithm) unsupported() error {
+	return fmt.Errorf("file algorithm %d: %w", int(a), ErrUnsupportedAlgorithm)
 }
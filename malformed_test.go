@@ -0,0 +1,78 @@
+package kognit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// noPanic runs fn, turning a panic into an error.
+func noPanic(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn()
+}
+
+// The archives in testdata/malformed, written by make_malformed.py, are each
+// broken in one way. Decoding them must fail cleanly; listing them may
+// succeed when only entry data is damaged, but must not panic either.
+func TestMalformedArchives(t *testing.T) {
+	files, err := ioutil.ReadDir(filepath.Join("testdata", "malformed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no malformed archives in testdata")
+	}
+
+	for _, f := range files {
+		path := filepath.Join("testdata", "malformed", f.Name())
+		var algo DirectoryCompressionAlgorithm
+		switch {
+		case strings.HasSuffix(path, ".zip"):
+			algo = ZIP
+		case strings.HasSuffix(path, ".tar.gz"):
+			algo = TAR
+		case strings.HasSuffix(path, ".tar.zst"):
+			algo = ZSTD
+		case strings.HasSuffix(path, ".tar.bz2"):
+			algo = BZIP2
+		default:
+			t.Fatalf("%s: unknown extension", f.Name())
+		}
+
+		t.Run(f.Name(), func(t *testing.T) {
+			err := noPanic(func() error { return DecodeInto(path, filepath.Join(t.TempDir(), "out"), algo) })
+			if err == nil || strings.HasPrefix(err.Error(), "panic") {
+				t.Errorf("DecodeInto = %v, want an error", err)
+			}
+
+			if algo == TAR {
+				err := noPanic(func() error {
+					stream, err := os.Open(path)
+					if err != nil {
+						return err
+					}
+					defer stream.Close()
+					return DecodeTarStream(stream, filepath.Join(t.TempDir(), "stream"))
+				})
+				if err == nil || strings.HasPrefix(err.Error(), "panic") {
+					t.Errorf("DecodeTarStream = %v, want an error", err)
+				}
+			}
+
+			if err := noPanic(func() error { _, err := ListArchive(path); return err }); err != nil && strings.HasPrefix(err.Error(), "panic") {
+				t.Errorf("ListArchive: %v", err)
+			}
+			if err := noPanic(func() error { _, err := UncompressedSize(path, algo); return err }); err != nil && strings.HasPrefix(err.Error(), "panic") {
+				t.Errorf("UncompressedSize: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package kognit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ManifestMismatchError lists every difference found by VerifyExtracted.
+// Paths are relative to the verified directory and use forward slashes.
+type ManifestMismatchError struct {
+	Missing    []string
+	Extra      []string
+	Mismatched []string
+}
+
+func (e *ManifestMismatchError) Error() string {
+	return fmt.Sprintf("extracted tree does not match manifest: %d missing, %d extra, %d mismatched",
+		len(e.Missing), len(e.Extra), len(e.Mismatched))
+}
+
+// GenerateManifest writes a sha256sum-style manifest of every regular file
+// under src to manifestPath.
+func GenerateManifest(src, manifestPath string) error {
+	hashes, err := hashTree(src, manifestPath)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(hashes))
+	for name := range hashes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	f, err := os.Create(manifestPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	for _, name := range names {
+		if _, err := fmt.Fprintf(w, "%s  %s\n", hashes[name], name); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// VerifyExtracted re-hashes every file under dest and compares it against
+// the manifest, returning a *ManifestMismatchError if they differ.
+func VerifyExtracted(dest string, manifestPath string) error {
+	expected, err := readManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+
+	actual, err := hashTree(dest, manifestPath)
+	if err != nil {
+		return err
+	}
+
+	mismatch := &ManifestMismatchError{}
+	for name, sum := range expected {
+		got, ok := actual[name]
+		if !ok {
+			mismatch.Missing = append(mismatch.Missing, name)
+		} else if got != sum {
+			mismatch.Mismatched = append(mismatch.Mismatched, name)
+		}
+	}
+	for name := range actual {
+		if _, ok := expected[name]; !ok {
+			mismatch.Extra = append(mismatch.Extra, name)
+		}
+	}
+
+	if len(mismatch.Missing)+len(mismatch.Extra)+len(mismatch.Mismatched) == 0 {
+		return nil
+	}
+	sort.Strings(mismatch.Missing)
+	sort.Strings(mismatch.Extra)
+	sort.Strings(mismatch.Mismatched)
+	return mismatch
+}
+
+func readManifest(manifestPath string) (map[string]string, error) {
+	f, err := os.Open(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseManifest(f)
+}
+
+func parseManifest(r io.Reader) (map[string]string, error) {
+	entries := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if scanner.Text() == "" {
+			continue
+		}
+		parts := strings.SplitN(scanner.Text(), "  ", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("malformed manifest line %d", line)
+		}
+		entries[parts[1]] = parts[0]
+	}
+	return entries, scanner.Err()
+}
+
+// hashTree hashes every regular file under root, keyed by its slash-separated
+// path relative to root. The manifest itself is skipped if it lives there.
+func hashTree(root, manifestPath string) (map[string]string, error) {
+	files, err := allDirFiles(root, ArchiveOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	manifestAbs, err := filepath.Abs(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := map[string]string{}
+	for _, file := range files {
+		if abs, err := filepath.Abs(file); err == nil && abs == manifestAbs {
+			continue
+		}
+
+		rel, err := filepath.Rel(root, file)
+		if err != nil {
+			return nil, err
+		}
+
+		sum, err := hashFile(file)
+		if err != nil {
+			return nil, err
+		}
+		hashes[filepath.ToSlash(rel)] = sum
+	}
+	return hashes, nil
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,58 @@
+package kognit
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestVerifyExtractedReportsTampering(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	writeTree(t, src, map[string]string{
+		"a.txt":     "alpha",
+		"sub/b.txt": "bravo",
+		"sub/c.txt": "charlie",
+	})
+	manifest := filepath.Join(dir, "MANIFEST")
+	if err := GenerateManifest(src, manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := filepath.Join(dir, "backup.tar.gz")
+	if err := NewArchiver(TAR).Encode(src, archive); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out")
+	if err := DecodeInto(archive, out, TAR); err != nil {
+		t.Fatal(err)
+	}
+	restored := filepath.Join(out, src)
+	if err := VerifyExtracted(restored, manifest); err != nil {
+		t.Fatalf("untouched restore: %v", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(restored, "sub", "b.txt"), []byte("tampered"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(restored, "sub", "c.txt")); err != nil {
+		t.Fatal(err)
+	}
+	writeTree(t, restored, map[string]string{"new.txt": "extra"})
+
+	var mismatch *ManifestMismatchError
+	if err := VerifyExtracted(restored, manifest); !errors.As(err, &mismatch) {
+		t.Fatalf("VerifyExtracted = %v, want a *ManifestMismatchError", err)
+	}
+	want := &ManifestMismatchError{
+		Missing:    []string{"sub/c.txt"},
+		Extra:      []string{"new.txt"},
+		Mismatched: []string{"sub/b.txt"},
+	}
+	if !reflect.DeepEqual(mismatch, want) {
+		t.Errorf("mismatch %+v, want %+v", mismatch, want)
+	}
+}
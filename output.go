@@ -0,0 +1,79 @@
+package kognit
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+)
+
+// pendingFile is an output being written to a temporary file next to its
+// destination. It only appears under the final name once commit succeeds,
+// so an interrupted encode never leaves a truncated file that looks valid.
+type pendingFile struct {
+	*os.File
+	dest string
+	done bool
+	// durable makes commit also sync the directory, so that the rename
+	// itself survives a crash.
+	durable bool
+}
+
+func createPending(dest string) (*pendingFile, error) {
+	// ioutil.TempFile creates files readable by the owner only, whatever
+	// the umask. Creating the file with 0666 leaves the umask to decide, as
+	// os.Create does.
+	for i := 0; ; i++ {
+		suffix := make([]byte, 4)
+		if _, err := rand.Read(suffix); err != nil {
+			return nil, err
+		}
+		name := filepath.Join(filepath.Dir(dest), "."+filepath.Base(dest)+"."+hex.EncodeToString(suffix)+".tmp")
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if os.IsExist(err) && i < 100 {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &pendingFile{File: f, dest: dest}, nil
+	}
+}
+
+// commit closes the file and moves it into place.
+func (p *pendingFile) commit() error {
+	p.done = true
+	if err := p.Close(); err != nil {
+		os.Remove(p.Name())
+		return err
+	}
+	if err := os.Rename(p.Name(), p.dest); err != nil {
+		os.Remove(p.Name())
+		return err
+	}
+	if p.durable {
+		return syncDir(filepath.Dir(p.dest))
+	}
+	return nil
+}
+
+// syncDir commits the entries of dir, such as a file just renamed into it,
+// to stable storage.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return syncFile(d)
+}
+
+// discard removes the temporary file unless it was committed. It is meant
+// to be deferred right after createPending.
+func (p *pendingFile) discard() {
+	if p.done {
+		return
+	}
+	p.Close()
+	os.Remove(p.Name())
+}
@@ -0,0 +1,78 @@
+package kognit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFailedEncodeLeavesNoOutput(t *testing.T) {
+	for _, algo := range []DirectoryCompressionAlgorithm{ZIP, TAR} {
+		dir := t.TempDir()
+		writeTree(t, dir, map[string]string{"src/a.txt": "written first"})
+		out := filepath.Join(dir, "out")
+		if err := os.Mkdir(out, 0755); err != nil {
+			t.Fatal(err)
+		}
+		dest := filepath.Join(out, "archive"+algo.extension())
+
+		// The second file is gone by the time the encoder reaches it, after
+		// the first has been written.
+		files := []archiveFile{
+			{path: filepath.Join(dir, "src", "a.txt"), name: "a.txt"},
+			{path: filepath.Join(dir, "src", "missing.txt"), name: "missing.txt"},
+		}
+		if err := algo.encode(files, dest, ArchiveOptions{}); !os.IsNotExist(err) {
+			t.Errorf("%s: encode = %v, want a not-exist error", algo.extension(), err)
+		}
+
+		left, err := ioutil.ReadDir(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range left {
+			t.Errorf("%s: %s left behind after a failed encode", algo.extension(), f.Name())
+		}
+	}
+}
+
+func TestDurableSyncsArchiveAndDirectory(t *testing.T) {
+	var synced []string
+	defer func(orig func(syncer) error) { syncFile = orig }(syncFile)
+	syncFile = func(f syncer) error {
+		if d, ok := f.(*os.File); ok {
+			synced = append(synced, d.Name())
+		} else {
+			synced = append(synced, "archive")
+		}
+		return f.Sync()
+	}
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	writeTree(t, src, map[string]string{"a.txt": "alpha"})
+	out := filepath.Join(dir, "out")
+	if err := os.Mkdir(out, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, algo := range []DirectoryCompressionAlgorithm{ZIP, TAR} {
+		synced = nil
+		if err := NewArchiver(algo).Encode(src, filepath.Join(out, "plain"+algo.extension())); err != nil {
+			t.Fatal(err)
+		}
+		if len(synced) != 0 {
+			t.Errorf("%s: synced %q without Durable", algo.extension(), synced)
+		}
+
+		if err := NewArchiver(algo, WithDurable()).Encode(src, filepath.Join(out, "durable"+algo.extension())); err != nil {
+			t.Fatal(err)
+		}
+		// The data before the rename, then the directory entry after it.
+		if want := []string{"archive", out}; strings.Join(synced, " ") != strings.Join(want, " ") {
+			t.Errorf("%s: synced %q, want %q", algo.extension(), synced, want)
+		}
+	}
+}
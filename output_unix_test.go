@@ -0,0 +1,39 @@
+//go:build !windows
+// +build !windows
+
+package kognit
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestOutputModeFollowsUmask(t *testing.T) {
+	for _, tt := range []struct {
+		umask int
+		want  os.FileMode
+	}{
+		{0022, 0644},
+		{0077, 0600},
+	} {
+		old := syscall.Umask(tt.umask)
+		dir := t.TempDir()
+		writeTree(t, dir, map[string]string{"src/a.txt": "a"})
+		dest := filepath.Join(dir, "out.tar.gz")
+		err := NewArchiver(TAR).Encode(filepath.Join(dir, "src"), dest)
+		syscall.Umask(old)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := os.Stat(dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != tt.want {
+			t.Errorf("umask %03o: archive mode %v, want %v", tt.umask, info.Mode().Perm(), tt.want)
+		}
+	}
+}
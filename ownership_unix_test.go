@@ -0,0 +1,137 @@
+//go:build !windows
+// +build !windows
+
+package kognit
+
+import (
+	"archive/tar"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestTarHeaderOwnership(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	writeTree(t, src, map[string]string{"a.txt": "alpha"})
+	path := filepath.Join(src, "a.txt")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := info.Sys().(*syscall.Stat_t)
+
+	archive := filepath.Join(dir, "out.tar.gz")
+	if err := NewArchiver(TAR).Encode(src, archive); err != nil {
+		t.Fatal(err)
+	}
+	h := readTarHeaders(t, archive)[filepath.ToSlash(path)]
+	if h == nil {
+		t.Fatalf("%s not archived", path)
+	}
+	if h.Uid != int(st.Uid) || h.Gid != int(st.Gid) {
+		t.Errorf("header owner %d:%d, want %d:%d", h.Uid, h.Gid, st.Uid, st.Gid)
+	}
+	if u, err := user.LookupId(strconv.Itoa(int(st.Uid))); err == nil && h.Uname != u.Username {
+		t.Errorf("header Uname = %q, want %q", h.Uname, u.Username)
+	}
+	if g, err := user.LookupGroupId(strconv.Itoa(int(st.Gid))); err == nil && h.Gname != g.Name {
+		t.Errorf("header Gname = %q, want %q", h.Gname, g.Name)
+	}
+}
+
+func TestTarOwnershipRestoredAsRoot(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("restoring another owner needs root")
+	}
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "owned.tar.gz")
+	// No names, so the numeric ids are used whatever users exist here.
+	writeTarGz(t, archive, []tarEntry{
+		{header: tar.Header{Name: "owned.txt", Typeflag: tar.TypeReg, Uid: 1234, Gid: 5678}, content: "owned"},
+	})
+
+	out := filepath.Join(dir, "out")
+	if err := DecodeInto(archive, out, TAR); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(out, "owned.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := info.Sys().(*syscall.Stat_t); st.Uid != 1234 || st.Gid != 5678 {
+		t.Errorf("restored owner %d:%d, want 1234:5678", st.Uid, st.Gid)
+	}
+}
+
+func TestNestedRestrictiveDirectories(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "nested.tar.gz")
+	mtime := func(day int) time.Time { return time.Date(2020, 1, day, 0, 0, 0, 0, time.UTC) }
+	dirs := []struct {
+		name string
+		mode os.FileMode
+		day  int
+	}{
+		{"top", 0555, 1},
+		{"top/mid", 0500, 2},
+		{"top/mid/leaf", 0700, 3},
+	}
+	var entries []tarEntry
+	for _, d := range dirs {
+		entries = append(entries, tarEntry{header: tar.Header{
+			Name: d.name + "/", Typeflag: tar.TypeDir, Mode: int64(d.mode), ModTime: mtime(d.day), Uid: 1234, Gid: 5678,
+		}})
+	}
+	// Children come after their directories, as tar writes them, so each
+	// one is created inside a directory whose restored mode would forbid it.
+	for _, d := range dirs {
+		entries = append(entries, tarEntry{
+			header:  tar.Header{Name: d.name + "/file.txt", Typeflag: tar.TypeReg, Mode: 0400, ModTime: mtime(10), Uid: 1234, Gid: 5678},
+			content: d.name,
+		})
+	}
+	writeTarGz(t, archive, entries)
+
+	out := filepath.Join(dir, "out")
+	t.Cleanup(func() {
+		filepath.Walk(out, func(path string, info os.FileInfo, err error) error {
+			if err == nil && info.IsDir() {
+				os.Chmod(path, 0755)
+			}
+			return nil
+		})
+	})
+	if err := DecodeInto(archive, out, TAR); err != nil {
+		t.Fatal(err)
+	}
+
+	root := os.Geteuid() == 0
+	for _, d := range dirs {
+		info, err := os.Stat(filepath.Join(out, d.name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != d.mode {
+			t.Errorf("%s mode %v, want %v", d.name, info.Mode().Perm(), d.mode)
+		}
+		if !info.ModTime().Equal(mtime(d.day)) {
+			t.Errorf("%s mtime %v, want %v", d.name, info.ModTime(), mtime(d.day))
+		}
+		if st := info.Sys().(*syscall.Stat_t); root && (st.Uid != 1234 || st.Gid != 5678) {
+			t.Errorf("%s owner %d:%d, want 1234:5678", d.name, st.Uid, st.Gid)
+		}
+
+		file, err := os.Stat(filepath.Join(out, d.name, "file.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if file.Mode().Perm() != 0400 || !file.ModTime().Equal(mtime(10)) {
+			t.Errorf("%s/file.txt mode %v, mtime %v", d.name, file.Mode().Perm(), file.ModTime())
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package kognit
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]CompressionAlgorithm{}
+)
+
+// RegisterAlgorithm makes a third-party algorithm available under name to
+// LookupAlgorithm and CompressItem, alongside the built-in ones. Names are
+// case-insensitive. It panics if a is nil or name is already taken, so it
+// is meant to be called from an init function.
+func RegisterAlgorithm(name string, a CompressionAlgorithm) {
+	if a == nil {
+		panic("kognit: RegisterAlgorithm algorithm is nil")
+	}
+	name = strings.ToLower(name)
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, dup := registry[name]; dup || isBuiltinAlgorithm(name) {
+		panic("kognit: algorithm name already in use: " + name)
+	}
+	registry[name] = a
+}
+
+// LookupAlgorithm returns the built-in or registered algorithm called name.
+func LookupAlgorithm(name string) (CompressionAlgorithm, error) {
+	name = strings.ToLower(name)
+
+	if a, ok := directoryAlgorithms[name]; ok {
+		return a, nil
+	}
+	if a, ok := fileAlgorithms[name]; ok {
+		return a, nil
+	}
+	if a, ok := imageAlgorithms[name]; ok {
+		return a, nil
+	}
+	if a, ok := registeredAlgorithm(name); ok {
+		return a, nil
+	}
+	return nil, fmt.Errorf("%s: %w", name, ErrUnsupportedAlgorithm)
+}
+
+// Algorithms returns the names of every built-in and registered algorithm,
+// sorted.
+func Algorithms() []string {
+	names := []string{}
+	for name := range directoryAlgorithms {
+		names = append(names, name)
+	}
+	for name := range fileAlgorithms {
+		names = append(names, name)
+	}
+	for name := range imageAlgorithms {
+		names = append(names, name)
+	}
+
+	registryMu.RLock()
+	for name := range registry {
+		names = append(names, name)
+	}
+	registryMu.RUnlock()
+
+	sort.Strings(names)
+	return names
+}
+
+func registeredAlgorithm(name string) (CompressionAlgorithm, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	a, ok := registry[name]
+	return a, ok
+}
+
+func isBuiltinAlgorithm(name string) bool {
+	_, isDir := directoryAlgorithms[name]
+	_, isFile := fileAlgorithms[name]
+	_, isImage := imageAlgorithms[name]
+	return isDir || isFile || isImage
+}
@@ -0,0 +1,120 @@
+package kognit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// reverseAlgorithm "compresses" a file by writing its bytes backwards to
+// path.rev, and undoes that on Decode.
+type reverseAlgorithm struct{}
+
+func reverseBytes(b []byte) []byte {
+	out := make([]byte, len(b))
+	for i, c := range b {
+		out[len(b)-1-i] = c
+	}
+	return out
+}
+
+func (reverseAlgorithm) Encode(src string) error {
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(src+".rev", reverseBytes(data), 0644)
+}
+
+func (reverseAlgorithm) Decode(src string) error {
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(strings.TrimSuffix(src, ".rev"), reverseBytes(data), 0644)
+}
+
+var testAlgorithms int
+
+// registerTestAlgorithm registers a under a fresh name and unregisters it
+// when the test ends, so tests can run repeatedly.
+func registerTestAlgorithm(t *testing.T, a CompressionAlgorithm) string {
+	t.Helper()
+	testAlgorithms++
+	name := fmt.Sprintf("Test-Reverse-%d", testAlgorithms)
+	RegisterAlgorithm(name, a)
+	t.Cleanup(func() {
+		registryMu.Lock()
+		delete(registry, strings.ToLower(name))
+		registryMu.Unlock()
+	})
+	return name
+}
+
+func TestRegisteredAlgorithm(t *testing.T) {
+	name := registerTestAlgorithm(t, reverseAlgorithm{})
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"notes.txt": "hello"})
+	src := filepath.Join(dir, "notes.txt")
+
+	// Registered algorithms have no family, so any item type takes them.
+	if err := CompressItem(src, File, name); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(src + ".rev"); err != nil || string(data) != "olleh" {
+		t.Fatalf("notes.txt.rev = %q, %v", data, err)
+	}
+
+	a, err := LookupAlgorithm(strings.ToUpper(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTree(t, dir, map[string]string{"notes.txt": "overwritten"})
+	if err := a.Decode(src + ".rev"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(src); err != nil || string(data) != "hello" {
+		t.Errorf("decoded notes.txt = %q, %v", data, err)
+	}
+}
+
+func TestRegisterAlgorithmPanics(t *testing.T) {
+	name := registerTestAlgorithm(t, reverseAlgorithm{})
+	for what, register := range map[string]func(){
+		"nil algorithm": func() { RegisterAlgorithm("test-nil", nil) },
+		"taken name":    func() { RegisterAlgorithm(strings.ToUpper(name), reverseAlgorithm{}) },
+		"built-in name": func() { RegisterAlgorithm("zip", reverseAlgorithm{}) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("registering a %s did not panic", what)
+				}
+			}()
+			register()
+		}()
+	}
+}
+
+func TestAlgorithmsListsBuiltinsAndRegistered(t *testing.T) {
+	builtins := []string{
+		"zip", "tar", "zstd", "bzip2",
+		"flate", "deflate", "gzip", "huffman", "lzw", "rle",
+		"jpeg", "jpeg2000", "png", "gif", "webp",
+	}
+	sort.Strings(builtins)
+	if got := Algorithms(); !reflect.DeepEqual(got, builtins) {
+		t.Errorf("Algorithms() = %q, want %q", got, builtins)
+	}
+
+	name := registerTestAlgorithm(t, reverseAlgorithm{})
+	want := append([]string{strings.ToLower(name)}, builtins...)
+	sort.Strings(want)
+	if got := Algorithms(); !reflect.DeepEqual(got, want) {
+		t.Errorf("after registering %s, Algorithms() = %q, want %q", name, got, want)
+	}
+}
@@ -0,0 +1,103 @@
+package kognit
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+type ResampleFilter int
+
+const (
+	NearestNeighbor ResampleFilter = iota
+	Bilinear
+)
+
+// fitSize scales w x h down to fit within maxW x maxH, keeping the aspect
+// ratio. A zero limit leaves that dimension unconstrained and images are
+// never scaled up.
+func fitSize(w, h, maxW, maxH int) (int, int) {
+	scale := 1.0
+	if maxW > 0 && w > maxW {
+		scale = math.Min(scale, float64(maxW)/float64(w))
+	}
+	if maxH > 0 && h > maxH {
+		scale = math.Min(scale, float64(maxH)/float64(h))
+	}
+	if scale == 1.0 {
+		return w, h
+	}
+
+	nw := int(math.Round(float64(w) * scale))
+	nh := int(math.Round(float64(h) * scale))
+	if nw < 1 {
+		nw = 1
+	}
+	if nh < 1 {
+		nh = 1
+	}
+	return nw, nh
+}
+
+func resizeImage(img image.Image, w, h int, filter ResampleFilter) image.Image {
+	src := img.Bounds()
+	if src.Dx() == w && src.Dy() == h {
+		return img
+	}
+
+	dst := image.NewRGBA64(image.Rect(0, 0, w, h))
+	xRatio := float64(src.Dx()) / float64(w)
+	yRatio := float64(src.Dy()) / float64(h)
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			sx := (float64(x)+0.5)*xRatio - 0.5
+			sy := (float64(y)+0.5)*yRatio - 0.5
+
+			switch filter {
+			case Bilinear:
+				dst.SetRGBA64(x, y, bilinearAt(img, src, sx, sy))
+			default:
+				px := src.Min.X + clamp(int(math.Round(sx)), 0, src.Dx()-1)
+				py := src.Min.Y + clamp(int(math.Round(sy)), 0, src.Dy()-1)
+				dst.Set(x, y, img.At(px, py))
+			}
+		}
+	}
+	return dst
+}
+
+func bilinearAt(img image.Image, b image.Rectangle, sx, sy float64) color.RGBA64 {
+	x0 := int(math.Floor(sx))
+	y0 := int(math.Floor(sy))
+	fx := sx - float64(x0)
+	fy := sy - float64(y0)
+
+	at := func(x, y int) [4]float64 {
+		x = b.Min.X + clamp(x, 0, b.Dx()-1)
+		y = b.Min.Y + clamp(y, 0, b.Dy()-1)
+		r, g, bl, a := img.At(x, y).RGBA()
+		return [4]float64{float64(r), float64(g), float64(bl), float64(a)}
+	}
+
+	c00, c10 := at(x0, y0), at(x0+1, y0)
+	c01, c11 := at(x0, y0+1), at(x0+1, y0+1)
+
+	var out [4]uint16
+	for i := range out {
+		top := c00[i]*(1-fx) + c10[i]*fx
+		bottom := c01[i]*(1-fx) + c11[i]*fx
+		out[i] = uint16(math.Round(top*(1-fy) + bottom*fy))
+	}
+	return color.RGBA64{R: out[0], G: out[1], B: out[2], A: out[3]}
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
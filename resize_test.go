@@ -0,0 +1,46 @@
+package kognit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResizeKeepsAspectRatio(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "wide.png")
+	writePNG(t, src, gradient(1000, 500))
+
+	for _, algo := range []ImageCompressionAlgorithm{JPEG, PNG, GIF} {
+		for _, filter := range []ResampleFilter{NearestNeighbor, Bilinear} {
+			dest := filepath.Join(dir, fmt.Sprintf("out-%d%s", filter, algo.extension()))
+			if err := algo.EncodeTo(src, dest, ImageOptions{MaxWidth: 500, Resample: filter}); err != nil {
+				t.Fatal(err)
+			}
+			if config, _ := imageConfig(t, dest); config.Width != 500 || config.Height != 250 {
+				t.Errorf("%s with filter %d is %dx%d, want 500x250", filepath.Base(dest), filter, config.Width, config.Height)
+			}
+			os.Remove(dest)
+		}
+	}
+}
+
+func TestFitSize(t *testing.T) {
+	for _, tt := range []struct {
+		w, h, maxW, maxH int
+		wantW, wantH     int
+	}{
+		{1000, 500, 500, 0, 500, 250},
+		{1000, 500, 0, 100, 200, 100},
+		{1000, 500, 500, 100, 200, 100},
+		{1000, 500, 0, 0, 1000, 500},
+		// Never scaled up.
+		{100, 50, 500, 500, 100, 50},
+		{1000, 1, 10, 0, 10, 1},
+	} {
+		if w, h := fitSize(tt.w, tt.h, tt.maxW, tt.maxH); w != tt.wantW || h != tt.wantH {
+			t.Errorf("fitSize(%d, %d, %d, %d) = %dx%d, want %dx%d", tt.w, tt.h, tt.maxW, tt.maxH, w, h, tt.wantW, tt.wantH)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package kognit
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want int64
+	}{
+		{"512", 512},
+		{"1.5K", 1536},
+		{"50MB", 50e6},
+		{"2 gib", 2 << 30},
+		{"8388607TiB", 8388607 << 40},
+	} {
+		if got, err := ParseSize(tt.in); err != nil || got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, %v; want %d", tt.in, got, err, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "-1", "12XB", "99999999TB", "8388608TiB", "9223372036854775808"} {
+		if got, err := ParseSize(in); err == nil {
+			t.Errorf("ParseSize(%q) = %d; want an error", in, got)
+		}
+	}
+}
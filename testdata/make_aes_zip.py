@@ -0,0 +1,68 @@
+#!/usr/bin/env python3
+"""Writes the WinZip AES fixtures used by zipcrypt_test.go.
+
+    make_aes_zip.py aes.zip           AE-1 and AE-2 entries, stored and deflated
+    make_aes_zip.py aes-tampered.zip  one AE-2 entry with a flipped ciphertext byte
+
+The password is "hunter2". Encryption uses openssl for AES-ECB, from which
+WinZip's little-endian counter mode is built.
+"""
+import hashlib, hmac, os, struct, subprocess, sys, zlib
+
+PASSWORD = b"hunter2"
+
+
+def ecb(key, data):
+    return subprocess.run(["openssl", "enc", "-aes-%d-ecb" % (len(key) * 8), "-K", key.hex(), "-nopad"],
+                          input=data, capture_output=True, check=True).stdout
+
+
+def entry(name, data, strength, version, deflate_level=None, tamper=False):
+    keylen = 8 + 8 * strength
+    salt = os.urandom(keylen // 2)
+    derived = hashlib.pbkdf2_hmac("sha1", PASSWORD, salt, 1000, 2 * keylen + 2)
+    enc_key, auth_key, verifier = derived[:keylen], derived[keylen:2 * keylen], derived[2 * keylen:]
+
+    payload = data
+    if deflate_level is not None:
+        c = zlib.compressobj(deflate_level, zlib.DEFLATED, -15)
+        payload = c.compress(data) + c.flush()
+
+    blocks = (len(payload) + 15) // 16
+    counters = b"".join(struct.pack("<Q", i + 1) + b"\0" * 8 for i in range(blocks))
+    ciphertext = bytearray(a ^ b for a, b in zip(payload, ecb(enc_key, counters)))
+    mac = hmac.new(auth_key, bytes(ciphertext), hashlib.sha1).digest()[:10]
+    if tamper:
+        # Inside the stored block's data, past its 5-byte header.
+        ciphertext[10] ^= 0x04
+
+    body = salt + verifier + bytes(ciphertext) + mac
+    crc = zlib.crc32(data) if version == 1 else 0
+    method = 8 if deflate_level is not None else 0
+    extra = struct.pack("<HHH2sBH", 0x9901, 7, version, b"AE", strength, method)
+    return name.encode(), body, crc, len(data), extra
+
+
+def write(path, entries):
+    out, central = b"", b""
+    for name, body, crc, size, extra in entries:
+        offset = len(out)
+        out += struct.pack("<IHHHHHIIIHH", 0x04034b50, 51, 1, 99, 0, 0x21, crc, len(body), size,
+                           len(name), len(extra)) + name + extra + body
+        central += struct.pack("<IHHHHHHIIIHHHHHII", 0x02014b50, 51, 51, 1, 99, 0, 0x21, crc, len(body), size,
+                               len(name), len(extra), 0, 0, 0, 0, offset) + name + extra
+    end = struct.pack("<IHHHHIIH", 0x06054b50, 0, 0, len(entries), len(entries), len(central), len(out), 0)
+    with open(path, "wb") as f:
+        f.write(out + central + end)
+
+
+if __name__ == "__main__":
+    path = sys.argv[1]
+    if os.path.basename(path) == "aes-tampered.zip":
+        write(path, [entry("fox.txt", b"the quick brown fox jumps over the lazy dog", 3, 2, deflate_level=0, tamper=True)])
+    else:
+        write(path, [
+            entry("a/one.txt", b"hello aes " * 100, 3, 2, deflate_level=9),
+            entry("a/two.txt", b"plain stored", 1, 1),
+            entry("a/three.txt", b"x" * 5000, 2, 1, deflate_level=9),
+        ])
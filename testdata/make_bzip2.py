@@ -0,0 +1,34 @@
+#!/usr/bin/env python3
+"""Writes tree.tar.bz2, the bzip2 fixture used by compressors_test.go.
+
+    make_bzip2.py tree.tar.bz2
+
+It holds a directory, two files and a relative symlink, as GNU tar with
+-j would write them.
+"""
+import io, sys, tarfile
+
+ENTRIES = [
+    ("tree", tarfile.DIRTYPE, b"", 0o755, None),
+    ("tree/a.txt", tarfile.REGTYPE, b"alpha\n", 0o644, None),
+    ("tree/sub", tarfile.DIRTYPE, b"", 0o750, None),
+    ("tree/sub/b.txt", tarfile.REGTYPE, b"bravo " * 100, 0o600, None),
+    ("tree/link", tarfile.SYMTYPE, b"", 0o777, "a.txt"),
+]
+
+
+def main(path):
+    with tarfile.open(path, "w:bz2", format=tarfile.PAX_FORMAT) as tf:
+        for name, kind, data, mode, target in ENTRIES:
+            info = tarfile.TarInfo(name)
+            info.type = kind
+            info.mode = mode
+            info.mtime = 1600000000
+            info.size = len(data)
+            if target:
+                info.linkname = target
+            tf.addfile(info, io.BytesIO(data))
+
+
+if __name__ == "__main__":
+    main(sys.argv[1])
@@ -0,0 +1,65 @@
+#!/usr/bin/env python3
+"""Writes the malformed archives in malformed/ used by malformed_test.go.
+
+Each file is a small valid archive broken in one way, named after the
+breakage. Run from testdata/.
+"""
+import bz2, gzip, io, os, struct, tarfile, zipfile
+
+OUT = "malformed"
+
+
+def tar_bytes():
+    buf = io.BytesIO()
+    with tarfile.open(fileobj=buf, mode="w", format=tarfile.PAX_FORMAT) as tf:
+        for name, data in [("a.txt", b"alpha " * 200), ("sub/b.txt", b"bravo")]:
+            info = tarfile.TarInfo(name)
+            info.size = len(data)
+            info.mtime = 0
+            tf.addfile(info, io.BytesIO(data))
+    return buf.getvalue()
+
+
+def gz(data):
+    return gzip.compress(data, mtime=0)
+
+
+def zip_bytes():
+    buf = io.BytesIO()
+    with zipfile.ZipFile(buf, "w", zipfile.ZIP_DEFLATED) as zf:
+        zf.writestr(zipfile.ZipInfo("a.txt", (1980, 1, 1, 0, 0, 0)), b"alpha " * 200, zipfile.ZIP_DEFLATED)
+        zf.writestr(zipfile.ZipInfo("b.txt", (1980, 1, 1, 0, 0, 0)), b"bravo")
+    return buf.getvalue()
+
+
+def patch(data, offset, new):
+    return data[:offset] + new + data[offset + len(new):]
+
+
+def write(name, data):
+    with open(os.path.join(OUT, name), "wb") as f:
+        f.write(data)
+
+
+t = tar_bytes()
+write("truncated.tar.gz", gz(t)[:60])
+write("gzip-header-only.tar.gz", gz(t)[:10])
+write("corrupt-deflate.tar.gz", patch(gz(t), 20, b"\xff" * 16))
+write("bad-checksum.tar.gz", gz(patch(t, 148, b"000000\x00 ")))
+write("bad-size.tar.gz", gz(patch(t, 124, b"zzzzzzzzzzz\x00")))
+write("truncated-entry.tar.gz", gz(t[:700]))
+write("truncated.tar.bz2", bz2.compress(t)[:40])
+write("garbage.tar.zst", b"\x28\xb5\x2f\xfd" + b"\xde\xad\xbe\xef" * 8)
+
+z = zip_bytes()
+eocd = z.rindex(b"PK\x05\x06")
+cdir = struct.unpack_from("<I", z, eocd + 16)[0]
+write("truncated.zip", z[: len(z) // 2])
+write("bogus-directory-offset.zip", patch(z, eocd + 16, struct.pack("<I", len(z) + 1000)))
+write("bogus-directory-size.zip", patch(z, eocd + 12, struct.pack("<I", 0x7FFFFFFF)))
+# The local header offset of the first central directory record, then the
+# compressed size of the second, which is stored, so it reads past the end.
+write("bogus-entry-offset.zip", patch(z, cdir + 42, struct.pack("<I", 0x7FFFFFF0)))
+write("bogus-entry-size.zip", patch(z, cdir + 46 + len("a.txt") + 20, struct.pack("<I", 0x7FFFFFFF)))
+write("bad-local-header.zip", patch(z, 0, b"PK\x03\x05"))
+write("corrupt-deflate.zip", patch(z, 40, b"\xff" * 16))
@@ -0,0 +1,266 @@
+package kognit
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Split archives are the archive's bytes cut into volumes named dest.001,
+// dest.002 and so on, the layout split(1) and 7-Zip produce, so they can
+// also be joined with cat.
+const firstVolume = ".001"
+
+func volumeName(dest string, i int) string {
+	return fmt.Sprintf("%s.%03d", dest, i+1)
+}
+
+// archiveOutput is where encode writes an archive: a single pendingFile or
+// a sequence of volumes.
+type archiveOutput interface {
+	io.Writer
+	syncer
+	commit() error
+	discard()
+}
+
+func createOutput(dest string, opts ArchiveOptions) (archiveOutput, error) {
+	if opts.VolumeSize > 0 {
+		return &volumeWriter{dest: dest, size: opts.VolumeSize, durable: opts.Durable}, nil
+	}
+	f, err := createPending(dest)
+	if err != nil {
+		return nil, err
+	}
+	f.durable = opts.Durable
+	return singleOutput{f}, nil
+}
+
+// singleOutput is an archive written as one file. Its commit removes any
+// volumes an earlier split encode left at the same destination.
+type singleOutput struct {
+	*pendingFile
+}
+
+func (s singleOutput) commit() error {
+	if err := s.pendingFile.commit(); err != nil {
+		return err
+	}
+	return removeVolumes(s.dest, 0)
+}
+
+// volumeWriter starts a new volume whenever the current one reaches size.
+// Like pendingFile, no volume appears under its final name until commit.
+type volumeWriter struct {
+	dest string
+	size int64
+	// perm, when set, replaces createPending's default mode.
+	perm    os.FileMode
+	durable bool
+	volumes []*pendingFile
+	written int64
+}
+
+func (v *volumeWriter) Write(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(v.volumes) == 0 || v.written == v.size {
+			f, err := createPending(volumeName(v.dest, len(v.volumes)))
+			if err != nil {
+				return n, err
+			}
+			f.durable = v.durable
+			if v.perm != 0 {
+				if err := f.Chmod(v.perm); err != nil {
+					f.discard()
+					return n, err
+				}
+			}
+			v.volumes = append(v.volumes, f)
+			v.written = 0
+		}
+
+		chunk := p[n:]
+		if int64(len(chunk)) > v.size-v.written {
+			chunk = chunk[:v.size-v.written]
+		}
+		m, err := v.volumes[len(v.volumes)-1].Write(chunk)
+		n += m
+		v.written += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (v *volumeWriter) Sync() error {
+	for _, f := range v.volumes {
+		if err := f.Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commit moves every volume into place and removes what an earlier archive
+// left at dest: a single-file archive, which openArchive would read instead
+// of the volumes, and any volumes past the new last one.
+func (v *volumeWriter) commit() error {
+	for _, f := range v.volumes {
+		if err := f.commit(); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(v.dest); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return removeVolumes(v.dest, len(v.volumes))
+}
+
+// removeVolumes deletes the volumes of dest from index first onwards,
+// stopping at the first one missing.
+func removeVolumes(dest string, first int) error {
+	for i := first; ; i++ {
+		if err := os.Remove(volumeName(dest, i)); err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func (v *volumeWriter) discard() {
+	for _, f := range v.volumes {
+		f.discard()
+	}
+}
+
+// archiveReader is an archive opened for decoding, either a single file or
+// the concatenation of its volumes.
+type archiveReader interface {
+	io.ReadCloser
+	io.ReaderAt
+}
+
+// openArchive opens src, or the volumes src.001 onwards when src itself
+// does not exist. src may also name the first volume. It returns the
+// archive's total size.
+func openArchive(src string) (archiveReader, int64, error) {
+	base := strings.TrimSuffix(src, firstVolume)
+	if base == src {
+		f, err := os.Open(src)
+		if err == nil {
+			info, err := f.Stat()
+			if err != nil {
+				f.Close()
+				return nil, 0, err
+			}
+			return f, info.Size(), nil
+		}
+		if _, statErr := os.Stat(src + firstVolume); statErr != nil {
+			return nil, 0, err
+		}
+	}
+	return openVolumes(base)
+}
+
+// splitArchive returns the name the volumes of src are numbered from and
+// the size of its first volume, or src and 0 when it is a single file.
+func splitArchive(src string) (string, int64, error) {
+	base := strings.TrimSuffix(src, firstVolume)
+	if base == src {
+		_, err := os.Stat(src)
+		if !os.IsNotExist(err) {
+			return src, 0, err
+		}
+	}
+	info, err := os.Stat(base + firstVolume)
+	if err != nil {
+		return "", 0, err
+	}
+	return base, info.Size(), nil
+}
+
+func openVolumes(base string) (*volumeReader, int64, error) {
+	v := &volumeReader{}
+	for i := 0; ; i++ {
+		f, err := os.Open(volumeName(base, i))
+		if os.IsNotExist(err) && i > 0 {
+			break
+		}
+		if err != nil {
+			v.Close()
+			return nil, 0, err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			v.Close()
+			return nil, 0, err
+		}
+		v.files = append(v.files, f)
+		v.starts = append(v.starts, v.size)
+		v.size += info.Size()
+	}
+	return v, v.size, nil
+}
+
+type volumeReader struct {
+	files  []*os.File
+	starts []int64
+	size   int64
+	offset int64
+}
+
+func (v *volumeReader) Read(p []byte) (int, error) {
+	n, err := v.ReadAt(p, v.offset)
+	v.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (v *volumeReader) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		if off >= v.size {
+			return n, io.EOF
+		}
+		// The last volume starting at or before off holds it.
+		i := sort.Search(len(v.starts), func(i int) bool { return v.starts[i] > off }) - 1
+		end := v.size
+		if i+1 < len(v.starts) {
+			end = v.starts[i+1]
+		}
+
+		chunk := p[n:]
+		if int64(len(chunk)) > end-off {
+			chunk = chunk[:end-off]
+		}
+		m, err := v.files[i].ReadAt(chunk, off-v.starts[i])
+		n += m
+		off += int64(m)
+		if err != nil && err != io.EOF {
+			return n, err
+		}
+		if m < len(chunk) {
+			return n, io.ErrUnexpectedEOF
+		}
+	}
+	return n, nil
+}
+
+func (v *volumeReader) Close() error {
+	var first error
+	for _, f := range v.files {
+		if err := f.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
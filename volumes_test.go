@@ -0,0 +1,122 @@
+package kognit
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSplitArchiveEntryPoints(t *testing.T) {
+	// Random content does not compress, so the archive spans several volumes.
+	noise := make([]byte, 4000)
+	rand.New(rand.NewSource(1)).Read(noise)
+
+	for _, algo := range []DirectoryCompressionAlgorithm{ZIP, TAR} {
+		t.Run(algo.extension(), func(t *testing.T) {
+			dir := t.TempDir()
+			src := filepath.Join(dir, "src")
+			writeTree(t, src, map[string]string{"noise.bin": string(noise), "small.txt": "small"})
+			dest := filepath.Join(dir, "split"+algo.extension())
+			if err := NewArchiver(algo, WithVolumeSize(1024)).Encode(src, dest); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(volumeName(dest, 2)); err != nil {
+				t.Fatalf("expected at least three volumes: %v", err)
+			}
+
+			entries, err := ListArchive(dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) < 2 {
+				t.Errorf("ListArchive found %d entries, want at least 2", len(entries))
+			}
+
+			size, err := UncompressedSize(dest, algo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := int64(len(noise) + len("small")); size != want {
+				t.Errorf("UncompressedSize = %d, want %d", size, want)
+			}
+
+			other := ZIP
+			if algo == ZIP {
+				other = ZSTD
+			}
+			converted := filepath.Join(dir, "converted"+other.extension())
+			if err := ConvertArchive(dest, converted, algo, other); err != nil {
+				t.Fatal(err)
+			}
+			out := filepath.Join(dir, "out")
+			if err := DecodeInto(converted, out, other); err != nil {
+				t.Fatal(err)
+			}
+			assertTree(t, filepath.Join(out, src), map[string]string{"noise.bin": string(noise), "small.txt": "small"})
+		})
+	}
+}
+
+func TestAppendToSplitTar(t *testing.T) {
+	noise := make([]byte, 4000)
+	rand.New(rand.NewSource(1)).Read(noise)
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	writeTree(t, src, map[string]string{"noise.bin": string(noise)})
+	dest := filepath.Join(dir, "split.tar.gz")
+	if err := NewArchiver(TAR, WithVolumeSize(1024)).Encode(src, dest); err != nil {
+		t.Fatal(err)
+	}
+
+	added := filepath.Join(dir, "new.txt")
+	writeTree(t, dir, map[string]string{"new.txt": "new"})
+	if err := AppendToTar(dest, []string{added}); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(dir, "out")
+	if err := DecodeInto(dest, out, TAR); err != nil {
+		t.Fatal(err)
+	}
+	assertTree(t, filepath.Join(out, dir), map[string]string{"new.txt": "new", "src/noise.bin": string(noise)})
+}
+
+func TestReencodeReplacesStaleArchive(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dest := filepath.Join(dir, "out.tar.gz")
+	encode := func(content string, opts ...Option) {
+		t.Helper()
+		writeTree(t, src, map[string]string{"a.txt": content})
+		if err := NewArchiver(TAR, opts...).Encode(src, dest); err != nil {
+			t.Fatal(err)
+		}
+	}
+	decoded := func() string {
+		t.Helper()
+		out := filepath.Join(t.TempDir(), "out")
+		if err := DecodeInto(dest, out, TAR); err != nil {
+			t.Fatal(err)
+		}
+		return readTree(t, filepath.Join(out, src))["a.txt"]
+	}
+
+	encode("old")
+	encode("new", WithVolumeSize(50))
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Errorf("single-file archive left next to the volumes: %v", err)
+	}
+	if got := decoded(); got != "new" {
+		t.Errorf("after a split re-encode, a.txt = %q, want %q", got, "new")
+	}
+
+	encode("newer")
+	if _, err := os.Stat(volumeName(dest, 0)); !os.IsNotExist(err) {
+		t.Errorf("volumes left next to the single-file archive: %v", err)
+	}
+	if got := decoded(); got != "newer" {
+		t.Errorf("after a single-file re-encode, a.txt = %q, want %q", got, "newer")
+	}
+}
@@ -0,0 +1,28 @@
+//go:build webp && cgo
+// +build webp,cgo
+
+package kognit
+
+import (
+	"image"
+
+	"github.com/chai2010/webp"
+)
+
+func encodeWebP(img image.Image, dest string, opts ImageOptions) error {
+	quality, err := lossyQuality(opts)
+	if err != nil {
+		return err
+	}
+
+	f, err := createPending(dest)
+	if err != nil {
+		return err
+	}
+	defer f.discard()
+
+	if err := webp.Encode(f, img, &webp.Options{Quality: float32(quality)}); err != nil {
+		return err
+	}
+	return f.commit()
+}
@@ -0,0 +1,37 @@
+//go:build webp && cgo
+// +build webp,cgo
+
+package kognit
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/chai2010/webp"
+)
+
+func TestWebPEncode(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "photo.png")
+	writePNG(t, src, gradient(40, 30))
+
+	if err := WEBP.Encode(src); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "photo.webp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		t.Fatalf("photo.webp does not start with a RIFF WEBP header")
+	}
+	config, err := webp.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Width != 40 || config.Height != 30 {
+		t.Errorf("photo.webp is %dx%d, want 40x30", config.Width, config.Height)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !webp || !cgo
+// +build !webp !cgo
+
+package kognit
+
+import (
+	"fmt"
+	"image"
+)
+
+// ErrWebPUnavailable wraps ErrUnsupportedFormat, so callers checking for
+// an unsupported format catch it too.
+var ErrWebPUnavailable = fmt.Errorf("WebP encoding requires building with cgo and the webp tag: %w", ErrUnsupportedFormat)
+
+func encodeWebP(img image.Image, dest string, opts ImageOptions) error {
+	return ErrWebPUnavailable
+}
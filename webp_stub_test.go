@@ -0,0 +1,25 @@
+//go:build !webp || !cgo
+// +build !webp !cgo
+
+package kognit
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWebPUnavailable(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "photo.png")
+	writePNG(t, src, gradient(16, 16))
+
+	err := WEBP.Encode(src)
+	if !errors.Is(err, ErrWebPUnavailable) || !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("WEBP.Encode = %v, want ErrWebPUnavailable wrapping ErrUnsupportedFormat", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "photo.webp")); !os.IsNotExist(err) {
+		t.Errorf("photo.webp left behind: %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+//go:build darwin
+// +build darwin
+
+package kognit
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// The syscall package has no xattr wrappers for darwin, so these call the
+// system calls directly. The position argument is only meaningful for
+// resource forks read piecewise and is always zero here.
+
+func listxattr(path string, dest []byte) (int, error) {
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	n, _, errno := syscall.Syscall6(syscall.SYS_LISTXATTR,
+		uintptr(unsafe.Pointer(p)), uintptr(bufPtr(dest)), uintptr(len(dest)), 0, 0, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(n), nil
+}
+
+func getxattr(path, name string, dest []byte) (int, error) {
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	a, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return 0, err
+	}
+	n, _, errno := syscall.Syscall6(syscall.SYS_GETXATTR,
+		uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(a)), uintptr(bufPtr(dest)), uintptr(len(dest)), 0, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(n), nil
+}
+
+func setxattr(path, name string, value []byte) error {
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return err
+	}
+	a, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall6(syscall.SYS_SETXATTR,
+		uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(a)), uintptr(bufPtr(value)), uintptr(len(value)), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// bufPtr returns a pointer to b's data, or nil for an empty b so that the
+// kernel reports the size needed instead of writing.
+func bufPtr(b []byte) unsafe.Pointer {
+	if len(b) == 0 {
+		return nil
+	}
+	return unsafe.Pointer(&b[0])
+}
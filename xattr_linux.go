@@ -0,0 +1,18 @@
+//go:build linux
+// +build linux
+
+package kognit
+
+import "syscall"
+
+func listxattr(path string, dest []byte) (int, error) {
+	return syscall.Listxattr(path, dest)
+}
+
+func getxattr(path, name string, dest []byte) (int, error) {
+	return syscall.Getxattr(path, name, dest)
+}
+
+func setxattr(path, name string, value []byte) error {
+	return syscall.Setxattr(path, name, value, 0)
+}
@@ -0,0 +1,16 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package kognit
+
+import "errors"
+
+var ErrXattrsUnavailable = errors.New("extended attributes are only supported on Linux and macOS")
+
+func readXattrs(path string) (map[string]string, error) {
+	return nil, ErrXattrsUnavailable
+}
+
+func writeXattrs(path string, xattrs map[string]string) error {
+	return ErrXattrsUnavailable
+}
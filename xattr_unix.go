@@ -0,0 +1,61 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package kognit
+
+import (
+	"bytes"
+	"syscall"
+)
+
+// readXattrs returns the extended attributes of path. On macOS these include
+// the resource fork, as com.apple.ResourceFork.
+func readXattrs(path string) (map[string]string, error) {
+	size, err := listxattr(path, nil)
+	if err == syscall.ENOTSUP {
+		return nil, nil
+	}
+	if err != nil || size == 0 {
+		return nil, err
+	}
+
+	list := make([]byte, size)
+	n, err := listxattr(path, list)
+	if err != nil {
+		return nil, err
+	}
+
+	xattrs := map[string]string{}
+	for _, name := range bytes.Split(list[:n], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+
+		size, err := getxattr(path, string(name), nil)
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, size)
+		n, err := getxattr(path, string(name), value)
+		if err != nil {
+			return nil, err
+		}
+		xattrs[string(name)] = string(value[:n])
+	}
+	return xattrs, nil
+}
+
+// writeXattrs restores xattrs on path. Attributes the destination cannot
+// hold, or that need privileges we lack such as trusted.*, are skipped.
+func writeXattrs(path string, xattrs map[string]string) error {
+	for name, value := range xattrs {
+		err := setxattr(path, name, []byte(value))
+		if err == syscall.ENOTSUP || err == syscall.EPERM {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package kognit
+
+import (
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+const testXattr = "user.kognit.test"
+
+func TestXattrsRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	writeTree(t, src, map[string]string{"a.txt": "alpha", "sub/b.txt": "bravo"})
+	tagged := filepath.Join(src, "sub", "b.txt")
+	if err := setxattr(tagged, testXattr, []byte("kept")); err != nil {
+		if err == syscall.ENOTSUP || err == syscall.EPERM {
+			t.Skipf("%s does not hold user xattrs: %v", dir, err)
+		}
+		t.Fatal(err)
+	}
+
+	archive := filepath.Join(dir, "out.tar.gz")
+	a := NewArchiver(TAR, WithXattrs())
+	if err := a.Encode(src, archive); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTarHeaders(t, archive)[filepath.ToSlash(tagged)].PAXRecords[paxXattr+testXattr]; got != "kept" {
+		t.Errorf("PAX record %s = %q, want %q", testXattr, got, "kept")
+	}
+
+	out := filepath.Join(dir, "out")
+	if err := a.Decode(archive, out); err != nil {
+		t.Fatal(err)
+	}
+	xattrs, err := readXattrs(filepath.Join(out, tagged))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if xattrs[testXattr] != "kept" {
+		t.Errorf("restored xattrs = %v, want %s=kept", xattrs, testXattr)
+	}
+
+	// Zip has nowhere to put them, so the option is ignored rather than
+	// failing the encode.
+	if err := NewArchiver(ZIP, WithXattrs()).Encode(src, filepath.Join(dir, "out.zip")); err != nil {
+		t.Errorf("zip with Xattrs: %v", err)
+	}
+}
+
+func TestXattrsSkipVanished(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "alpha"})
+	files := []archiveFile{
+		{path: filepath.Join(dir, "a.txt"), name: "a.txt"},
+		{path: filepath.Join(dir, "gone.txt"), name: "gone.txt"},
+	}
+	dest := filepath.Join(dir, "out.tar.gz")
+	if err := TAR.encode(files, dest, ArchiveOptions{Xattrs: true, SkipVanished: true}); err != nil {
+		t.Fatal(err)
+	}
+	headers := readTarHeaders(t, dest)
+	if headers["a.txt"] == nil || headers["gone.txt"] != nil {
+		t.Errorf("archived %v, want only a.txt", headers)
+	}
+}
@@ -0,0 +1,241 @@
+package kognit
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// archive/zip refuses encrypted entries, so they are read raw from the
+// archive and decrypted here. Both the traditional PKWARE scheme
+// (ZipCrypto) and WinZip's AES extension are supported.
+const (
+	zipFlagEncrypted      = 0x1
+	zipFlagDataDescriptor = 0x8
+	zipMethodAES          = 99
+	zipExtraAES           = 0x9901
+)
+
+var ErrWrongPassword = errors.New("wrong or missing password for encrypted zip entry")
+
+type zipEntryReader struct {
+	io.Reader
+	io.Closer
+}
+
+// openZipEntry opens f for reading, decrypting it with password when the
+// entry is encrypted. archive is the zip file f was read from.
+func openZipEntry(f *zip.File, archive io.ReaderAt, password string) (io.ReadCloser, error) {
+	if f.Flags&zipFlagEncrypted == 0 {
+		return f.Open()
+	}
+	if password == "" {
+		return nil, fmt.Errorf("%s: %w", f.Name, ErrWrongPassword)
+	}
+
+	offset, err := f.DataOffset()
+	if err != nil {
+		return nil, err
+	}
+	raw := io.NewSectionReader(archive, offset, zipSize(f.CompressedSize64))
+
+	var r io.Reader
+	method := f.Method
+	checkCRC := true
+	if method == zipMethodAES {
+		r, method, checkCRC, err = openWinZipAES(f, raw, password)
+	} else {
+		r, err = openZipCrypto(f, raw, password)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rc io.ReadCloser
+	switch method {
+	case zip.Store:
+		rc = ioutil.NopCloser(r)
+	case zip.Deflate:
+		rc = flate.NewReader(r)
+	default:
+		return nil, fmt.Errorf("%s: %w", f.Name, zip.ErrAlgorithm)
+	}
+
+	if !checkCRC {
+		return rc, nil
+	}
+	// A wrong ZipCrypto password slips past the one-byte header check about
+	// once in 256 tries, in which case only the checksum catches it.
+	crcErr := zip.ErrChecksum
+	if f.Method != zipMethodAES {
+		crcErr = fmt.Errorf("%s: %w", f.Name, ErrWrongPassword)
+	}
+	return zipEntryReader{Reader: &crcReader{r: rc, hash: crc32.NewIEEE(), want: f.CRC32, err: crcErr}, Closer: rc}, nil
+}
+
+// openZipCrypto checks password against the 12-byte encryption header and
+// returns the decrypted compressed data that follows it.
+func openZipCrypto(f *zip.File, raw *io.SectionReader, password string) (io.Reader, error) {
+	keys := newZipCryptoKeys(password)
+
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(raw, header); err != nil {
+		return nil, err
+	}
+	keys.XORKeyStream(header, header)
+
+	check := byte(f.CRC32 >> 24)
+	if f.Flags&zipFlagDataDescriptor != 0 {
+		check = byte(f.ModifiedTime >> 8)
+	}
+	if header[11] != check {
+		return nil, fmt.Errorf("%s: %w", f.Name, ErrWrongPassword)
+	}
+	return cipher.StreamReader{S: keys, R: raw}, nil
+}
+
+// zipCryptoKeys is the traditional PKWARE stream cipher, used for
+// decryption only.
+type zipCryptoKeys [3]uint32
+
+func newZipCryptoKeys(password string) *zipCryptoKeys {
+	k := &zipCryptoKeys{0x12345678, 0x23456789, 0x34567890}
+	for i := 0; i < len(password); i++ {
+		k.update(password[i])
+	}
+	return k
+}
+
+func (k *zipCryptoKeys) update(b byte) {
+	k[0] = crc32.IEEETable[byte(k[0])^b] ^ (k[0] >> 8)
+	k[1] = (k[1]+(k[0]&0xff))*134775813 + 1
+	k[2] = crc32.IEEETable[byte(k[2])^byte(k[1]>>24)] ^ (k[2] >> 8)
+}
+
+func (k *zipCryptoKeys) XORKeyStream(dst, src []byte) {
+	for i, c := range src {
+		t := k[2] | 2
+		p := c ^ byte((t*(t^1))>>8)
+		k.update(p)
+		dst[i] = p
+	}
+}
+
+// openWinZipAES decrypts an AE-1 or AE-2 entry. It returns the entry's real
+// compression method and whether its CRC is meaningful: AE-2 leaves it zero
+// and relies on the HMAC alone.
+func openWinZipAES(f *zip.File, raw *io.SectionReader, password string) (io.Reader, uint16, bool, error) {
+	version, strength, method, ok := winZipAESExtra(f.Extra)
+	if !ok || strength < 1 || strength > 3 {
+		return nil, 0, false, fmt.Errorf("%s: %w", f.Name, zip.ErrFormat)
+	}
+
+	keyLen := 8 + 8*int(strength)
+	saltLen := keyLen / 2
+	size := raw.Size() - int64(saltLen) - 2 - 10
+	if size < 0 {
+		return nil, 0, false, fmt.Errorf("%s: %w", f.Name, zip.ErrFormat)
+	}
+
+	header := make([]byte, saltLen+2)
+	if _, err := raw.ReadAt(header, 0); err != nil {
+		return nil, 0, false, err
+	}
+	code := make([]byte, 10)
+	if _, err := raw.ReadAt(code, raw.Size()-10); err != nil {
+		return nil, 0, false, err
+	}
+
+	keys := pbkdf2.Key([]byte(password), header[:saltLen], 1000, 2*keyLen+2, sha1.New)
+	if !hmac.Equal(keys[2*keyLen:], header[saltLen:]) {
+		return nil, 0, false, fmt.Errorf("%s: %w", f.Name, ErrWrongPassword)
+	}
+
+	block, err := aes.NewCipher(keys[:keyLen])
+	if err != nil {
+		return nil, 0, false, err
+	}
+	// The HMAC covers the whole ciphertext, so it is checked before anything
+	// is decrypted. Checking it as the data streams would only fire at EOF,
+	// which a deflate reader never asks for once it sees the final block.
+	data := io.NewSectionReader(raw, int64(saltLen)+2, size)
+	mac := hmac.New(sha1.New, keys[keyLen:2*keyLen])
+	if _, err := io.Copy(mac, data); err != nil {
+		return nil, 0, false, err
+	}
+	if !hmac.Equal(mac.Sum(nil)[:len(code)], code) {
+		return nil, 0, false, fmt.Errorf("%s: %w", f.Name, zip.ErrChecksum)
+	}
+
+	data = io.NewSectionReader(raw, int64(saltLen)+2, size)
+	r := cipher.StreamReader{S: &winZipCTR{block: block, used: aes.BlockSize}, R: data}
+	return r, method, version == 1, nil
+}
+
+// winZipAESExtra parses the 0x9901 extra field.
+func winZipAESExtra(extra []byte) (version uint16, strength byte, method uint16, ok bool) {
+	for len(extra) >= 4 {
+		tag := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		extra = extra[4:]
+		if size > len(extra) {
+			break
+		}
+		if tag == zipExtraAES && size >= 7 {
+			return binary.LittleEndian.Uint16(extra), extra[4], binary.LittleEndian.Uint16(extra[5:]), true
+		}
+		extra = extra[size:]
+	}
+	return 0, 0, 0, false
+}
+
+// winZipCTR is AES in counter mode with the little-endian counter, starting
+// at one, that WinZip uses instead of the usual big-endian one.
+type winZipCTR struct {
+	block   cipher.Block
+	counter uint64
+	stream  [aes.BlockSize]byte
+	used    int
+}
+
+func (c *winZipCTR) XORKeyStream(dst, src []byte) {
+	for i := range src {
+		if c.used == aes.BlockSize {
+			c.counter++
+			var ctr [aes.BlockSize]byte
+			binary.LittleEndian.PutUint64(ctr[:], c.counter)
+			c.block.Encrypt(c.stream[:], ctr[:])
+			c.used = 0
+		}
+		dst[i] = src[i] ^ c.stream[c.used]
+		c.used++
+	}
+}
+
+type crcReader struct {
+	r    io.Reader
+	hash hash.Hash32
+	want uint32
+	err  error
+}
+
+func (c *crcReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.hash.Write(p[:n])
+	if err == io.EOF && c.hash.Sum32() != c.want {
+		return n, c.err
+	}
+	return n, err
+}
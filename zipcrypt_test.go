@@ -0,0 +1,58 @@
+package kognit
+
+import (
+	"archive/zip"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDecodeEncryptedZip(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    map[string]string
+	}{
+		{"zipcrypto.zip", map[string]string{
+			"d/s.txt": strings.Repeat("secret contents ", 200),
+			"d/t.txt": "short\n",
+		}},
+		{"aes.zip", map[string]string{
+			"a/one.txt":   strings.Repeat("hello aes ", 100),
+			"a/two.txt":   "plain stored",
+			"a/three.txt": strings.Repeat("x", 5000),
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			archive := filepath.Join("testdata", tt.fixture)
+
+			dest := t.TempDir()
+			if err := NewArchiver(ZIP, WithZipPassword("hunter2")).Decode(archive, dest); err != nil {
+				t.Fatal(err)
+			}
+			assertTree(t, dest, tt.want)
+
+			for _, password := range []string{"", "hunter3"} {
+				err := NewArchiver(ZIP, WithZipPassword(password)).Decode(archive, t.TempDir())
+				if !errors.Is(err, ErrWrongPassword) {
+					t.Errorf("Decode with password %q = %v, want ErrWrongPassword", password, err)
+				}
+			}
+		})
+	}
+}
+
+// The tampered fixture is an AE-2 entry, which has no CRC, holding a
+// stored deflate block with one ciphertext byte flipped.
+func TestDecodeTamperedAESZip(t *testing.T) {
+	dest := t.TempDir()
+	err := NewArchiver(ZIP, WithZipPassword("hunter2")).Decode(filepath.Join("testdata", "aes-tampered.zip"), dest)
+	if !errors.Is(err, zip.ErrChecksum) {
+		t.Errorf("Decode = %v, want zip.ErrChecksum", err)
+	}
+	if files := readTree(t, dest); len(files) != 0 {
+		t.Errorf("tampered entry was extracted: %v", files)
+	}
+}